@@ -0,0 +1,28 @@
+package npminstall
+
+import (
+	"fmt"
+	"strings"
+)
+
+// verifyArchitecture checks that the native addons and executables installed
+// into the given node_modules directory can run on the target architecture.
+// Binaries in directories that packages name for other platforms, such as
+// prebuilds/darwin-x64, are not verified, as they are never loaded there.
+func verifyArchitecture(nodeModulesPath, arch string) error {
+	binaries, err := findForeignBinaries(nodeModulesPath, arch)
+	if err != nil {
+		return err
+	}
+
+	if len(binaries) == 0 {
+		return nil
+	}
+
+	var report []string
+	for _, binary := range binaries {
+		report = append(report, fmt.Sprintf("  %s", binary))
+	}
+
+	return fmt.Errorf("installed node_modules contain binaries that do not match the target architecture linux/%s:\n%s\ncheck that platform-specific optional dependencies resolved for linux/%s", arch, strings.Join(report, "\n"), arch)
+}
@@ -1,10 +1,12 @@
 package npminstall
 
 import (
+	"errors"
 	"fmt"
 	"os"
 	"path"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"time"
 
@@ -42,7 +44,7 @@ type ConfigurationManager interface {
 
 //go:generate faux --interface PruneProcess --output fakes/prune_process.go
 type PruneProcess interface {
-	ShouldRun(workingDir string, metadata map[string]interface{}, npmrcPath string) (run bool, sha string, err error)
+	ShouldRun(workingDir string, metadata map[string]interface{}, npmrcPath string) (run bool, layerMetadata map[string]interface{}, err error)
 	Run(modulesDir, cacheDir, workingDir, npmrcPath string, launch bool) error
 }
 
@@ -59,6 +61,11 @@ type SymlinkResolver interface {
 	Resolve(lockfilePath, layerPath string) error
 }
 
+//go:generate faux --interface NpmVersionResolver --output fakes/npm_version_resolver.go
+type NpmVersionResolver interface {
+	Resolve(projectPath string) (version string, err error)
+}
+
 func Build(entryResolver EntryResolver,
 	configurationManager ConfigurationManager,
 	buildManager BuildManager,
@@ -68,16 +75,92 @@ func Build(entryResolver EntryResolver,
 	sbomGenerator SBOMGenerator,
 	linker Symlinker,
 	environment EnvironmentConfig,
+	summer Summer,
+	fingerprinter Summer,
 	symlinkResolver SymlinkResolver,
+	npmVersionResolver NpmVersionResolver,
+	interrupt Interrupt,
 ) packit.BuildFunc {
-	return func(context packit.BuildContext) (packit.BuildResult, error) {
+	return func(context packit.BuildContext) (result packit.BuildResult, err error) {
 		logger.Title("%s %s", context.BuildpackInfo.Name, context.BuildpackInfo.Version)
 
-		globalNpmrcPath, err := configurationManager.DeterminePath("npmrc", context.Platform.Path, ".npmrc")
+		// Layers installed during this build only replace their previous
+		// contents once the whole build succeeded. When it fails or is
+		// interrupted, the previous contents are restored so that no layer is
+		// left with metadata that does not match its contents.
+		var transactions []layerTransaction
+
+		// The SBOMs of the node_modules layers are generated in the background
+		// from the layer contents, so that the build layer SBOM overlaps the
+		// launch install and both overlap the npm cache garbage collection.
+		// They are waited for before the layers change again.
+		var layers []packit.Layer
+		var pendingSBOMs []*pendingSBOM
+		waitForSBOMs := func() error {
+			defer func() { pendingSBOMs = nil }()
+
+			for _, pending := range pendingSBOMs {
+				formatter, err := pending.Wait(logger, context.BuildpackInfo.SBOMFormats)
+				if err != nil {
+					return err
+				}
+
+				for i := range layers {
+					if layers[i].Path == pending.layerPath {
+						layers[i].SBOM = formatter
+					}
+				}
+			}
+
+			return nil
+		}
+
+		defer func() {
+			for _, pending := range pendingSBOMs {
+				<-pending.done
+			}
+
+			if interrupted := interrupt.Err(); interrupted != nil {
+				logger.Process("Build interrupted, restoring the working directory")
+				result = packit.BuildResult{}
+				if err == nil || !errors.Is(err, ErrInterrupted) {
+					err = interrupted
+				}
+			}
+
+			if err == nil {
+				for _, transaction := range transactions {
+					if commitErr := transaction.Commit(); commitErr != nil {
+						result, err = packit.BuildResult{}, commitErr
+						break
+					}
+				}
+
+				if err == nil {
+					return
+				}
+			}
+
+			for i := len(transactions) - 1; i >= 0; i-- {
+				if rollbackErr := transactions[i].Rollback(); rollbackErr != nil {
+					logger.Subprocess("Warning: failed to restore previous layer contents: %s", rollbackErr)
+				}
+			}
+		}()
+
+		nodeHeadersPath, err := configurationManager.DeterminePath("node-headers", context.Platform.Path, "node-headers.tar.gz")
 		if err != nil {
 			return packit.BuildResult{}, err
 		}
 
+		globalNpmrcPath, _ := environment.Lookup("NPM_CONFIG_GLOBALCONFIG")
+		if globalNpmrcPath == "" {
+			globalNpmrcPath, err = configurationManager.DeterminePath("npmrc", context.Platform.Path, ".npmrc")
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+		}
+
 		logger.Process("Resolving installation process")
 
 		projectPath, err := libnodejs.FindProjectPath(context.WorkingDir)
@@ -86,6 +169,14 @@ func Build(entryResolver EntryResolver,
 		}
 
 		npmVersion, found := environment.Lookup("BP_NPM_VERSION")
+		if !found {
+			npmVersion, err = npmVersionResolver.Resolve(projectPath)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+			found = npmVersion != ""
+		}
+
 		if found {
 			logger.Process("Installling custom npm version %s", npmVersion)
 			args := []string{"install", fmt.Sprintf("npm@%s", npmVersion)}
@@ -123,13 +214,18 @@ func Build(entryResolver EntryResolver,
 
 		npmCacheLayer.Cache = true
 
+		locked, err := fs.Exists(filepath.Join(projectPath, "package-lock.json"))
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
 		process, cacheFound, err := buildManager.Resolve(projectPath)
 		if err != nil {
 			return packit.BuildResult{}, err
 		}
 
 		if cacheFound {
-			npmCacheLayer, err = UpdateNpmCacheLayer(logger, projectPath, npmCacheLayer)
+			npmCacheLayer, err = UpdateNpmCacheLayer(logger, fingerprinter, projectPath, npmCacheLayer)
 			if err != nil {
 				return packit.BuildResult{}, err
 			}
@@ -140,9 +236,39 @@ func Build(entryResolver EntryResolver,
 			return packit.BuildResult{}, err
 		}
 
+		npmCacheMaxSize, err := LookupNpmCacheMaxSize(environment)
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		// When the target architecture is not known the architecture of the
+		// build container is assumed.
+		targetArch := context.TargetInfo.Arch
+		if targetArch == "" {
+			targetArch = runtime.GOARCH
+		}
+
 		launch, build := entryResolver.MergeLayerTypes(NodeModules, context.Plan.Entries)
 
-		var layers []packit.Layer
+		// Without devDependencies 'npm prune' has nothing to remove, so the
+		// build layer is also used for launch instead of being copied into a
+		// separate launch layer. Packages that are extraneous to the lockfile
+		// are not pruned either: 'npm ci' installs into an empty node_modules,
+		// 'npm install' removes them itself and the rebuild process rejects
+		// vendored node_modules that 'npm ls' reports as extraneous. The layer
+		// is not shared when BP_KEEP_NODE_BUILD_CACHE keeps node_modules/.cache
+		// for launch, as the build cache layer holding it is not launched.
+		var devDependencies bool
+		if build && launch {
+			devDependencies, err = hasDevDependencies(projectPath)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+		}
+
+		keepBuildCache, _ := environment.Lookup("BP_KEEP_NODE_BUILD_CACHE")
+		share := build && launch && !devDependencies && keepBuildCache != "true"
+
 		var buildLayerPath string
 		if build {
 			layer, err := context.Layers.Get("build-modules")
@@ -151,26 +277,50 @@ func Build(entryResolver EntryResolver,
 			}
 			buildLayerPath = layer.Path
 
-			run, sha, err := process.ShouldRun(projectPath, layer.Metadata, globalNpmrcPath)
+			run, metadata, err := process.ShouldRun(projectPath, layer.Metadata, globalNpmrcPath)
 			if err != nil {
 				return packit.BuildResult{}, err
 			}
 
+			if !run && !locked {
+				run, err = generatedLockfileChanged(summer, layer.Path, layer.Metadata)
+				if err != nil {
+					return packit.BuildResult{}, err
+				}
+
+				if run {
+					logger.Process("Generated package-lock.json in layer %s does not match its metadata", layer.Path)
+					metadata = layer.Metadata
+				}
+			}
+
 			if run {
 				logger.Process("Executing build environment install process")
 
-				layer, err = layer.Reset()
+				var transaction layerTransaction
+				layer, transaction, err = beginLayerTransaction(layer, projectPath)
 				if err != nil {
 					return packit.BuildResult{}, err
 				}
+				transactions = append(transactions, transaction)
 
 				duration, err := clock.Measure(func() error {
-					return process.Run(layer.Path, npmCacheLayer.Path, projectPath, globalNpmrcPath, false)
+					return process.Run(layer.Path, npmCacheLayer.Path, projectPath, globalNpmrcPath, nodeHeadersPath, targetArch, false)
 				})
 				if err != nil {
 					return packit.BuildResult{}, err
 				}
 
+				err = interrupt.Err()
+				if err != nil {
+					return packit.BuildResult{}, err
+				}
+
+				err = verifyArchitecture(filepath.Join(layer.Path, "node_modules"), targetArch)
+				if err != nil {
+					return packit.BuildResult{}, err
+				}
+
 				err = linker.Link(filepath.Join(projectPath, "node_modules"), filepath.Join(layer.Path, "node_modules"))
 				if err != nil {
 					return packit.BuildResult{}, err
@@ -184,10 +334,15 @@ func Build(entryResolver EntryResolver,
 				logger.Action("Completed in %s", duration.Round(time.Millisecond))
 				logger.Break()
 
-				layer.Metadata = map[string]interface{}{
-					"cache_sha": sha,
+				if !locked {
+					metadata, err = exportGeneratedLockfile(logger, summer, projectPath, layer.Path, filepath.Join(context.WorkingDir, GeneratedLockfileReport), metadata)
+					if err != nil {
+						return packit.BuildResult{}, err
+					}
 				}
 
+				layer.Metadata = metadata
+
 				if globalNpmrcPath != "" {
 					layer.BuildEnv.Default("NPM_CONFIG_GLOBALCONFIG", globalNpmrcPath)
 				}
@@ -202,25 +357,7 @@ func Build(entryResolver EntryResolver,
 					logger.Subprocess("Skipping SBOM generation for Node Install")
 					logger.Break()
 				} else {
-					logger.GeneratingSBOM(layer.Path)
-
-					var sbomContent sbom.SBOM
-					duration, err = clock.Measure(func() error {
-						sbomContent, err = sbomGenerator.Generate(context.WorkingDir)
-						return err
-					})
-					if err != nil {
-						return packit.BuildResult{}, err
-					}
-					logger.Action("Completed in %s", duration.Round(time.Millisecond))
-					logger.Break()
-
-					logger.FormattingSBOM(context.BuildpackInfo.SBOMFormats...)
-
-					layer.SBOM, err = sbomContent.InFormats(context.BuildpackInfo.SBOMFormats...)
-					if err != nil {
-						return packit.BuildResult{}, err
-					}
+					pendingSBOMs = append(pendingSBOMs, generateSBOMInBackground(sbomGenerator, clock, layer.Path))
 				}
 			} else {
 				logger.Process("Reusing cached layer %s", layer.Path)
@@ -229,65 +366,147 @@ func Build(entryResolver EntryResolver,
 				if err != nil {
 					return packit.BuildResult{}, err
 				}
+
+				if !locked {
+					err = reportGeneratedLockfile(logger, layer.Path, filepath.Join(context.WorkingDir, GeneratedLockfileReport))
+					if err != nil {
+						return packit.BuildResult{}, err
+					}
+				}
 			}
 			layer.Build = true
 			layer.Cache = true
 
+			if share {
+				logger.Subprocess("Using layer %s for launch: the app has no devDependencies", layer.Path)
+				logger.Break()
+
+				layer.Launch = true
+				layer = setLaunchEnvironment(layer, projectPath, context.CNBPath)
+			}
+
 			layers = append(layers, layer)
 		}
 
-		if launch {
+		if share {
+			// The launch layer of a previous build is emptied and returned
+			// without flags so that the lifecycle does not restore it.
 			layer, err := context.Layers.Get("launch-modules")
 			if err != nil {
 				return packit.BuildResult{}, err
 			}
 
-			run, sha, err := process.ShouldRun(projectPath, layer.Metadata, globalNpmrcPath)
+			var transaction layerTransaction
+			layer, transaction, err = beginLayerTransaction(layer, projectPath)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+			transactions = append(transactions, transaction)
+
+			layers = append(layers, layer)
+		}
+
+		if launch && !share {
+			layer, err := context.Layers.Get("launch-modules")
 			if err != nil {
 				return packit.BuildResult{}, err
 			}
 
+			run, metadata, err := process.ShouldRun(projectPath, layer.Metadata, globalNpmrcPath)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+
+			if !run && !locked {
+				run, err = generatedLockfileChanged(summer, layer.Path, layer.Metadata)
+				if err != nil {
+					return packit.BuildResult{}, err
+				}
+
+				if run {
+					logger.Process("Generated package-lock.json in layer %s does not match its metadata", layer.Path)
+					metadata = layer.Metadata
+				}
+			}
+
 			if run {
 				logger.Process("Executing launch environment install process")
 
-				layer, err = layer.Reset()
+				var transaction layerTransaction
+				layer, transaction, err = beginLayerTransaction(layer, projectPath)
 				if err != nil {
 					return packit.BuildResult{}, err
 				}
+				transactions = append(transactions, transaction)
 
+				// Without devDependencies there is nothing to prune, so the build
+				// layer contents are copied for launch as they are.
+				var skipPrune, prune bool
 				if build {
-					err := fs.Copy(filepath.Join(buildLayerPath, "node_modules"), filepath.Join(projectPath, "node_modules"))
-					if err != nil {
-						return packit.BuildResult{}, err
+					skipPrune = !devDependencies
+					if skipPrune {
+						logger.Subprocess("Skipping 'npm prune': the app has no devDependencies")
+					} else {
+						// npm prune only removes files from the copy and replaces
+						// the lockfile it rewrites, so the copy may share the
+						// files of the build layer where they cannot be cloned.
+						copier := NewTreeCopier(CopyMethodReflink, CopyMethodHardlink, CopyMethodCopy)
+						err = copier.Copy(filepath.Join(buildLayerPath, "node_modules"), filepath.Join(projectPath, "node_modules"))
+						if err != nil {
+							return packit.BuildResult{}, err
+						}
+						prune = true
 					}
-					process = pruneProcess
 				}
 
 				duration, err := clock.Measure(func() error {
-					return process.Run(layer.Path, npmCacheLayer.Path, projectPath, globalNpmrcPath, true)
+					if skipPrune {
+						return NewTreeCopier().Copy(filepath.Join(buildLayerPath, "node_modules"), filepath.Join(layer.Path, "node_modules"))
+					}
+					if prune {
+						return pruneProcess.Run(layer.Path, npmCacheLayer.Path, projectPath, globalNpmrcPath, true)
+					}
+					return process.Run(layer.Path, npmCacheLayer.Path, projectPath, globalNpmrcPath, nodeHeadersPath, targetArch, true)
 				})
 				if err != nil {
 					return packit.BuildResult{}, err
 				}
+
+				err = interrupt.Err()
+				if err != nil {
+					return packit.BuildResult{}, err
+				}
+
 				targetLayerPath := layer.Path
 
 				if build {
-					err = fs.Move(filepath.Join(projectPath, "node_modules"), filepath.Join(layer.Path, "node_modules"))
-					if err != nil {
-						return packit.BuildResult{}, err
+					if !skipPrune {
+						err = fs.Move(filepath.Join(projectPath, "node_modules"), filepath.Join(layer.Path, "node_modules"))
+						if err != nil {
+							return packit.BuildResult{}, err
+						}
 					}
 
 					targetLayerPath = buildLayerPath
 				}
 
-				layer.ExecD = []string{filepath.Join(context.CNBPath, "bin", "setup-symlinks")}
+				err = verifyArchitecture(filepath.Join(layer.Path, "node_modules"), targetArch)
+				if err != nil {
+					return packit.BuildResult{}, err
+				}
 
 				err = linker.Link(filepath.Join(projectPath, "node_modules"), filepath.Join(targetLayerPath, "node_modules"))
 				if err != nil {
 					return packit.BuildResult{}, err
 				}
 
-				keepBuildCache, _ := environment.Lookup("BP_KEEP_NODE_BUILD_CACHE")
+				if build {
+					err = unlinkNodeBuildCache(filepath.Join(layer.Path, "node_modules"), filepath.Join(context.Layers.Path, LayerNameBuildCache))
+					if err != nil {
+						return packit.BuildResult{}, err
+					}
+				}
+
 				if keepBuildCache != "true" {
 					linkName := filepath.Join(layer.Path, "node_modules", ".cache")
 					err = os.RemoveAll(linkName)
@@ -317,15 +536,16 @@ func Build(entryResolver EntryResolver,
 				logger.Action("Completed in %s", duration.Round(time.Millisecond))
 				logger.Break()
 
-				layer.Metadata = map[string]interface{}{
-					"cache_sha": sha,
+				if !locked {
+					metadata, err = exportGeneratedLockfile(logger, summer, projectPath, layer.Path, filepath.Join(context.WorkingDir, GeneratedLockfileReport), metadata)
+					if err != nil {
+						return packit.BuildResult{}, err
+					}
 				}
 
-				layer.LaunchEnv.Default("NPM_CONFIG_LOGLEVEL", "error")
-				layer.LaunchEnv.Default("NODE_PROJECT_PATH", projectPath)
-				nodeModulesPath := filepath.Join(layer.Path, "node_modules")
-				layer.LaunchEnv.Append("PATH", filepath.Join(nodeModulesPath, ".bin"), string(os.PathListSeparator))
-				layer.LaunchEnv.Prepend("PATH", filepath.Join(nodeModulesPath, ".bin_local"), string(os.PathListSeparator))
+				layer.Metadata = metadata
+
+				layer = setLaunchEnvironment(layer, projectPath, context.CNBPath)
 
 				logger.EnvironmentVariables(layer)
 
@@ -333,25 +553,7 @@ func Build(entryResolver EntryResolver,
 					logger.Subprocess("Skipping SBOM generation for Node Install")
 					logger.Break()
 				} else {
-					logger.GeneratingSBOM(layer.Path)
-
-					var sbomContent sbom.SBOM
-					duration, err = clock.Measure(func() error {
-						sbomContent, err = sbomGenerator.Generate(context.WorkingDir)
-						return err
-					})
-					if err != nil {
-						return packit.BuildResult{}, err
-					}
-					logger.Action("Completed in %s", duration.Round(time.Millisecond))
-					logger.Break()
-
-					logger.FormattingSBOM(context.BuildpackInfo.SBOMFormats...)
-
-					layer.SBOM, err = sbomContent.InFormats(context.BuildpackInfo.SBOMFormats...)
-					if err != nil {
-						return packit.BuildResult{}, err
-					}
+					pendingSBOMs = append(pendingSBOMs, generateSBOMInBackground(sbomGenerator, clock, layer.Path))
 				}
 			} else {
 				logger.Process("Reusing cached layer %s", layer.Path)
@@ -361,6 +563,13 @@ func Build(entryResolver EntryResolver,
 						return packit.BuildResult{}, err
 					}
 				}
+
+				if !locked {
+					err = reportGeneratedLockfile(logger, layer.Path, filepath.Join(context.WorkingDir, GeneratedLockfileReport))
+					if err != nil {
+						return packit.BuildResult{}, err
+					}
+				}
 			}
 
 			layer.Launch = true
@@ -371,6 +580,11 @@ func Build(entryResolver EntryResolver,
 		exists, err := fs.Exists(npmCacheLayer.Path)
 		if exists {
 			if !fs.IsEmptyDir(npmCacheLayer.Path) {
+				npmCacheLayer, err = CollectNpmCacheGarbage(logger, projectPath, npmCacheLayer, npmCacheMaxSize)
+				if err != nil {
+					return packit.BuildResult{}, err
+				}
+
 				layers = append(layers, npmCacheLayer)
 			}
 		}
@@ -378,8 +592,53 @@ func Build(entryResolver EntryResolver,
 			return packit.BuildResult{}, err
 		}
 
+		err = waitForSBOMs()
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
+		if build {
+			buildCacheLayer, err := context.Layers.Get(LayerNameBuildCache)
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+
+			logger.Process("Persisting node_modules/.cache in layer %s", buildCacheLayer.Path)
+			if share {
+				err = shareNodeBuildCache(filepath.Join(buildLayerPath, "node_modules"), buildCacheLayer, filepath.Join(os.TempDir(), NODE_MODULES_CACHE))
+			} else {
+				err = linkNodeBuildCache(filepath.Join(buildLayerPath, "node_modules"), buildCacheLayer)
+			}
+			if err != nil {
+				return packit.BuildResult{}, err
+			}
+
+			buildCacheLayer.Build = true
+			buildCacheLayer.Cache = true
+
+			layers = append(layers, buildCacheLayer)
+		}
+
+		err = interrupt.Err()
+		if err != nil {
+			return packit.BuildResult{}, err
+		}
+
 		logger.Break()
 
 		return packit.BuildResult{Layers: layers}, nil
 	}
 }
+
+// setLaunchEnvironment configures a layer holding node_modules for launch.
+func setLaunchEnvironment(layer packit.Layer, projectPath, cnbPath string) packit.Layer {
+	layer.ExecD = []string{filepath.Join(cnbPath, "bin", "setup-symlinks")}
+
+	layer.LaunchEnv.Default("NPM_CONFIG_LOGLEVEL", "error")
+	layer.LaunchEnv.Default("NODE_PROJECT_PATH", projectPath)
+	nodeModulesPath := filepath.Join(layer.Path, "node_modules")
+	layer.LaunchEnv.Append("PATH", filepath.Join(nodeModulesPath, ".bin"), string(os.PathListSeparator))
+	layer.LaunchEnv.Prepend("PATH", filepath.Join(nodeModulesPath, ".bin_local"), string(os.PathListSeparator))
+
+	return layer
+}
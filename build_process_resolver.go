@@ -18,8 +18,8 @@ type Executable interface {
 
 //go:generate faux --interface BuildProcess --output fakes/build_process.go
 type BuildProcess interface {
-	ShouldRun(workingDir string, metadata map[string]interface{}, npmrcPath string) (run bool, sha string, err error)
-	Run(modulesDir, cacheDir, workingDir, npmrcPath string, launch bool) error
+	ShouldRun(workingDir string, metadata map[string]interface{}, npmrcPath string) (run bool, layerMetadata map[string]interface{}, err error)
+	Run(modulesDir, cacheDir, workingDir, npmrcPath, nodeHeadersPath, arch string, launch bool) error
 }
 
 //go:generate faux --interface Summer --output fakes/summer.go
@@ -34,18 +34,20 @@ type EnvironmentConfig interface {
 }
 
 type BuildProcessResolver struct {
-	logger  scribe.Logger
-	rebuild BuildProcess
-	install BuildProcess
-	ci      BuildProcess
+	logger      scribe.Logger
+	rebuild     BuildProcess
+	install     BuildProcess
+	ci          BuildProcess
+	environment EnvironmentConfig
 }
 
-func NewBuildProcessResolver(logger scribe.Logger, rebuild, install, ci BuildProcess) BuildProcessResolver {
+func NewBuildProcessResolver(logger scribe.Logger, rebuild, install, ci BuildProcess, environment EnvironmentConfig) BuildProcessResolver {
 	return BuildProcessResolver{
-		logger:  logger,
-		rebuild: rebuild,
-		install: install,
-		ci:      ci,
+		logger:      logger,
+		rebuild:     rebuild,
+		install:     install,
+		ci:          ci,
+		environment: environment,
 	}
 }
 
@@ -83,6 +85,15 @@ func (r BuildProcessResolver) Resolve(workingDir string) (BuildProcess, bool, er
 	r.logger.Action("%s", inputsMap)
 	r.logger.Break()
 
+	requireLockfile, err := r.environment.LookupBool("BP_NPM_REQUIRE_LOCKFILE")
+	if err != nil {
+		return nil, false, err
+	}
+
+	if requireLockfile && !locked {
+		return nil, false, fmt.Errorf("lockfile policy violation: BP_NPM_REQUIRE_LOCKFILE is enabled but no package-lock.json was found in %s: run 'npm install' locally and commit the generated package-lock.json", workingDir)
+	}
+
 	switch {
 	case !locked && vendored, locked && vendored && !cached:
 		r.logger.Subprocess("Selected NPM build process: 'npm rebuild'")
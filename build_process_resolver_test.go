@@ -2,6 +2,7 @@ package npminstall_test
 
 import (
 	"bytes"
+	"errors"
 	"os"
 	"path/filepath"
 	"testing"
@@ -24,7 +25,8 @@ func testBuildProcessResolver(t *testing.T, context spec.G, it spec.S) {
 		install *fakes.BuildProcess
 		ci      *fakes.BuildProcess
 
-		resolver npminstall.BuildProcessResolver
+		environment *fakes.EnvironmentConfig
+		resolver    npminstall.BuildProcessResolver
 
 		buffer *bytes.Buffer
 	)
@@ -38,15 +40,17 @@ func testBuildProcessResolver(t *testing.T, context spec.G, it spec.S) {
 		logger := scribe.NewLogger(buffer)
 
 		rebuild = &fakes.BuildProcess{}
-		rebuild.ShouldRunCall.Returns.Sha = "rebuild-sha"
+		rebuild.ShouldRunCall.Returns.LayerMetadata = map[string]interface{}{"cache_sha": "rebuild-sha"}
 
 		install = &fakes.BuildProcess{}
-		install.ShouldRunCall.Returns.Sha = "install-sha"
+		install.ShouldRunCall.Returns.LayerMetadata = map[string]interface{}{"cache_sha": "install-sha"}
 
 		ci = &fakes.BuildProcess{}
-		ci.ShouldRunCall.Returns.Sha = "ci-sha"
+		ci.ShouldRunCall.Returns.LayerMetadata = map[string]interface{}{"cache_sha": "ci-sha"}
 
-		resolver = npminstall.NewBuildProcessResolver(logger, rebuild, install, ci)
+		environment = &fakes.EnvironmentConfig{}
+
+		resolver = npminstall.NewBuildProcessResolver(logger, rebuild, install, ci, environment)
 	})
 
 	it.After(func() {
@@ -229,6 +233,38 @@ func testBuildProcessResolver(t *testing.T, context spec.G, it spec.S) {
 		})
 	})
 
+	context("when BP_NPM_REQUIRE_LOCKFILE is true", func() {
+		it.Before(func() {
+			environment.LookupBoolCall.Stub = func(key string) (bool, error) {
+				return key == "BP_NPM_REQUIRE_LOCKFILE", nil
+			}
+		})
+
+		context("and there is a package-lock.json", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "package-lock.json"), []byte(`{}`), 0600)).To(Succeed())
+			})
+
+			it("returns the ci process", func() {
+				buildProcess, _, err := resolver.Resolve(workingDir)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(buildProcess).To(Equal(ci))
+			})
+		})
+
+		context("and there is no package-lock.json", func() {
+			it.Before(func() {
+				Expect(os.MkdirAll(filepath.Join(workingDir, "node_modules"), os.ModePerm)).To(Succeed())
+			})
+
+			it("returns an error explaining the policy", func() {
+				_, _, err := resolver.Resolve(workingDir)
+				Expect(err).To(MatchError(ContainSubstring("BP_NPM_REQUIRE_LOCKFILE is enabled but no package-lock.json was found")))
+				Expect(err).To(MatchError(ContainSubstring("commit the generated package-lock.json")))
+			})
+		})
+	})
+
 	context("failure cases", func() {
 		var resolver npminstall.BuildProcessResolver
 
@@ -238,7 +274,7 @@ func testBuildProcessResolver(t *testing.T, context spec.G, it spec.S) {
 			Expect(err).NotTo(HaveOccurred())
 
 			logger := scribe.NewLogger(bytes.NewBuffer(nil))
-			resolver = npminstall.NewBuildProcessResolver(logger, rebuild, install, ci)
+			resolver = npminstall.NewBuildProcessResolver(logger, rebuild, install, ci, environment)
 		})
 
 		it.After(func() {
@@ -246,6 +282,17 @@ func testBuildProcessResolver(t *testing.T, context spec.G, it spec.S) {
 		})
 
 		context("Resolve", func() {
+			context("when BP_NPM_REQUIRE_LOCKFILE cannot be parsed", func() {
+				it.Before(func() {
+					environment.LookupBoolCall.Returns.Error = errors.New("failed to parse")
+				})
+
+				it("returns an error", func() {
+					_, _, err := resolver.Resolve(workingDir)
+					Expect(err).To(MatchError("failed to parse"))
+				})
+			})
+
 			context("when the working directory is unreadable", func() {
 				it.Before(func() {
 					Expect(os.Chmod(workingDir, 0000)).To(Succeed())
@@ -8,7 +8,10 @@ import (
 	"os"
 	"path/filepath"
 	"regexp"
+	"runtime"
+	"syscall"
 	"testing"
+	"time"
 
 	npminstall "github.com/paketo-buildpacks/npm-install"
 	"github.com/paketo-buildpacks/npm-install/fakes"
@@ -44,7 +47,11 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 		sbomGenerator        *fakes.SBOMGenerator
 		linker               *fakes.Symlinker
 		environment          *fakes.EnvironmentConfig
+		summer               *fakes.Summer
+		fingerprinter        *fakes.Summer
 		symlinkResolver      *fakes.SymlinkResolver
+		npmVersionResolver   *fakes.NpmVersionResolver
+		interrupt            npminstall.Interrupt
 
 		buffer *bytes.Buffer
 
@@ -73,8 +80,8 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 
 		buildProcess = &fakes.BuildProcess{}
 		buildProcess.ShouldRunCall.Returns.Run = true
-		buildProcess.ShouldRunCall.Returns.Sha = "some-sha"
-		buildProcess.RunCall.Stub = func(ld, cd, wd, rc string, l bool) error {
+		buildProcess.ShouldRunCall.Returns.LayerMetadata = map[string]interface{}{"cache_sha": "some-sha"}
+		buildProcess.RunCall.Stub = func(ld, cd, wd, rc, hp, a string, l bool) error {
 			err := os.MkdirAll(filepath.Join(ld, "node_modules"), os.ModePerm)
 			if err != nil {
 				return err
@@ -112,8 +119,18 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 		environment = &fakes.EnvironmentConfig{}
 		environment.LookupBoolCall.Returns.Bool = false
 
+		summer = &fakes.Summer{}
+		summer.SumCall.Returns.String = "some-lockfile-sha"
+
+		fingerprinter = &fakes.Summer{}
+		fingerprinter.SumCall.Returns.String = "some-npm-cache-sha"
+
 		symlinkResolver = &fakes.SymlinkResolver{}
 
+		npmVersionResolver = &fakes.NpmVersionResolver{}
+
+		interrupt = npminstall.NewInterrupt()
+
 		build = npminstall.Build(
 			entryResolver,
 			configurationManager,
@@ -124,7 +141,11 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 			sbomGenerator,
 			linker,
 			environment,
+			summer,
+			fingerprinter,
 			symlinkResolver,
+			npmVersionResolver,
+			interrupt,
 		)
 	})
 
@@ -158,7 +179,7 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 			})
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(len(result.Layers)).To(Equal(2))
+			Expect(len(result.Layers)).To(Equal(3))
 
 			buildLayer := result.Layers[0]
 			Expect(buildLayer.Name).To(Equal("build-modules"))
@@ -279,6 +300,19 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 			Expect(cacheLayer.Build).To(BeFalse())
 			Expect(cacheLayer.Launch).To(BeFalse())
 			Expect(cacheLayer.Cache).To(BeTrue())
+			Expect(cacheLayer.Metadata).To(HaveKeyWithValue("cache_size", int64(0)))
+
+			buildCacheLayer := result.Layers[2]
+			Expect(buildCacheLayer.Name).To(Equal(npminstall.LayerNameBuildCache))
+			Expect(buildCacheLayer.Path).To(Equal(filepath.Join(layersDir, npminstall.LayerNameBuildCache)))
+			Expect(buildCacheLayer.Build).To(BeTrue())
+			Expect(buildCacheLayer.Launch).To(BeFalse())
+			Expect(buildCacheLayer.Cache).To(BeTrue())
+			Expect(buildCacheLayer.Path).To(BeADirectory())
+
+			link, err := os.Readlink(filepath.Join(layersDir, "build-modules", "node_modules", ".cache"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(link).To(Equal(buildCacheLayer.Path))
 
 			Expect(configurationManager.DeterminePathCall.Receives.Typ).To(Equal("npmrc"))
 			Expect(configurationManager.DeterminePathCall.Receives.PlatformDir).To(Equal("some-platform-path"))
@@ -297,6 +331,63 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 			Expect(symlinkResolver.ResolveCall.Receives.LockfilePath).To(Equal(filepath.Join(workingDir, "package-lock.json")))
 			Expect(symlinkResolver.ResolveCall.Receives.LayerPath).To(Equal(filepath.Join(layersDir, "build-modules")))
 		})
+
+		context("when the build layer is reused", func() {
+			it.Before(func() {
+				buildProcess.ShouldRunCall.Returns.Run = false
+				Expect(os.MkdirAll(filepath.Join(layersDir, "build-modules", "node_modules"), os.ModePerm)).To(Succeed())
+			})
+
+			context("when node_modules/.cache is linked to the build cache layer", func() {
+				it.Before(func() {
+					Expect(os.MkdirAll(filepath.Join(layersDir, npminstall.LayerNameBuildCache, "babel-loader"), os.ModePerm)).To(Succeed())
+					Expect(os.Symlink(filepath.Join(layersDir, npminstall.LayerNameBuildCache), filepath.Join(layersDir, "build-modules", "node_modules", ".cache"))).To(Succeed())
+				})
+
+				it("keeps the cached contents", func() {
+					result, err := build(packit.BuildContext{
+						WorkingDir: workingDir,
+						Layers:     packit.Layers{Path: layersDir},
+						CNBPath:    cnbDir,
+						Plan: packit.BuildpackPlan{
+							Entries: []packit.BuildpackPlanEntry{
+								{Name: "node_modules"},
+							},
+						},
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(result.Layers[len(result.Layers)-1].Name).To(Equal(npminstall.LayerNameBuildCache))
+					Expect(filepath.Join(layersDir, "build-modules", "node_modules", ".cache", "babel-loader")).To(BeADirectory())
+				})
+			})
+
+			context("when node_modules/.cache is a directory left by a previous build", func() {
+				it.Before(func() {
+					Expect(os.MkdirAll(filepath.Join(layersDir, "build-modules", "node_modules", ".cache", "webpack"), os.ModePerm)).To(Succeed())
+				})
+
+				it("moves it into the build cache layer", func() {
+					_, err := build(packit.BuildContext{
+						WorkingDir: workingDir,
+						Layers:     packit.Layers{Path: layersDir},
+						CNBPath:    cnbDir,
+						Plan: packit.BuildpackPlan{
+							Entries: []packit.BuildpackPlanEntry{
+								{Name: "node_modules"},
+							},
+						},
+					})
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(filepath.Join(layersDir, npminstall.LayerNameBuildCache, "webpack")).To(BeADirectory())
+
+					link, err := os.Readlink(filepath.Join(layersDir, "build-modules", "node_modules", ".cache"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(link).To(Equal(filepath.Join(layersDir, npminstall.LayerNameBuildCache)))
+				})
+			})
+		})
 	})
 
 	context("when required during launch", func() {
@@ -528,7 +619,7 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 			})
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(len(result.Layers)).To(Equal(3))
+			Expect(len(result.Layers)).To(Equal(4))
 
 			buildLayer := result.Layers[0]
 			Expect(buildLayer.Name).To(Equal("build-modules"))
@@ -805,7 +896,7 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 			})
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(len(result.Layers)).To(Equal(3))
+			Expect(len(result.Layers)).To(Equal(4))
 
 			launchLayer := result.Layers[1]
 			Expect(launchLayer.Name).To(Equal("launch-modules"))
@@ -816,16 +907,346 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 			Expect(err).NotTo(HaveOccurred())
 		})
 
+		context("when BP_KEEP_NODE_BUILD_CACHE is true and the reused build layer links node_modules/.cache", func() {
+			it.Before(func() {
+				environment.LookupCall.Stub = func(key string) (string, bool) {
+					if key == "BP_KEEP_NODE_BUILD_CACHE" {
+						return "true", true
+					}
+					return "", false
+				}
+
+				Expect(os.WriteFile(filepath.Join(layersDir, "build-modules.toml"), []byte("[metadata]\n  cache_sha = \"some-build-sha\"\n"), 0600)).To(Succeed())
+				buildProcess.ShouldRunCall.Stub = func(_ string, metadata map[string]interface{}, _ string) (bool, map[string]interface{}, error) {
+					return metadata["cache_sha"] != "some-build-sha", map[string]interface{}{"cache_sha": "some-sha"}, nil
+				}
+
+				Expect(os.MkdirAll(filepath.Join(layersDir, "build-modules", "node_modules", "leftpad"), os.ModePerm)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(layersDir, npminstall.LayerNameBuildCache), os.ModePerm)).To(Succeed())
+				Expect(os.Symlink(filepath.Join(layersDir, npminstall.LayerNameBuildCache), filepath.Join(layersDir, "build-modules", "node_modules", ".cache"))).To(Succeed())
+			})
+
+			it("does not link node_modules/.cache in the launch layer to the build cache layer", func() {
+				_, err := build(packit.BuildContext{
+					WorkingDir: workingDir,
+					Layers:     packit.Layers{Path: layersDir},
+					CNBPath:    cnbDir,
+					Plan: packit.BuildpackPlan{
+						Entries: []packit.BuildpackPlanEntry{
+							{Name: "node_modules"},
+						},
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(filepath.Join(layersDir, "launch-modules", "node_modules", "leftpad")).To(BeADirectory())
+				Expect(filepath.Join(layersDir, "launch-modules", "node_modules", ".cache")).NotTo(BeAnExistingFile())
+
+				link, err := os.Readlink(filepath.Join(layersDir, "build-modules", "node_modules", ".cache"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link).To(Equal(filepath.Join(layersDir, npminstall.LayerNameBuildCache)))
+			})
+		})
+
+		context("when the app has no devDependencies", func() {
+			var buildContext packit.BuildContext
+
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "package.json"), []byte(`{"dependencies": {"leftpad": "^1.0.0"}}`), 0600)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(workingDir, "package-lock.json"), []byte(`{"lockfileVersion": 3, "packages": {"": {}, "node_modules/leftpad": {"version": "1.3.0"}}}`), 0600)).To(Succeed())
+
+				buildProcess.RunCall.Stub = func(ld, cd, wd, rc, hp, a string, l bool) error {
+					return os.MkdirAll(filepath.Join(ld, "node_modules", "leftpad"), os.ModePerm)
+				}
+
+				buildContext = packit.BuildContext{
+					WorkingDir: workingDir,
+					Layers:     packit.Layers{Path: layersDir},
+					CNBPath:    cnbDir,
+					Plan: packit.BuildpackPlan{
+						Entries: []packit.BuildpackPlanEntry{
+							{Name: "node_modules"},
+						},
+					},
+				}
+			})
+
+			it("uses the build layer for launch without pruning", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(pruneProcess.RunCall.CallCount).To(Equal(0))
+				Expect(buildProcess.RunCall.CallCount).To(Equal(1))
+				Expect(buffer.String()).To(ContainSubstring(fmt.Sprintf("Using layer %s for launch: the app has no devDependencies", filepath.Join(layersDir, "build-modules"))))
+
+				buildLayer := result.Layers[0]
+				Expect(buildLayer.Name).To(Equal("build-modules"))
+				Expect(buildLayer.Build).To(BeTrue())
+				Expect(buildLayer.Launch).To(BeTrue())
+				Expect(buildLayer.Cache).To(BeTrue())
+				Expect(buildLayer.LaunchEnv).To(Equal(packit.Environment{
+					"NPM_CONFIG_LOGLEVEL.default": "error",
+					"NODE_PROJECT_PATH.default":   workingDir,
+					"PATH.append":                 filepath.Join(layersDir, "build-modules", "node_modules", ".bin"),
+					"PATH.prepend":                filepath.Join(layersDir, "build-modules", "node_modules", ".bin_local"),
+					"PATH.delim":                  ":",
+				}))
+				Expect(buildLayer.ExecD).To(Equal([]string{filepath.Join(cnbDir, "bin", "setup-symlinks")}))
+				Expect(filepath.Join(buildLayer.Path, "node_modules", "leftpad")).To(BeADirectory())
+
+				launchLayer := result.Layers[1]
+				Expect(launchLayer.Name).To(Equal("launch-modules"))
+				Expect(launchLayer.Build).To(BeFalse())
+				Expect(launchLayer.Launch).To(BeFalse())
+				Expect(launchLayer.Cache).To(BeFalse())
+				Expect(filepath.Join(launchLayer.Path, "node_modules")).NotTo(BeAnExistingFile())
+			})
+
+			it("generates the SBOM of the shared layer once", func() {
+				result, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(sbomGenerator.GenerateCall.CallCount).To(Equal(1))
+				Expect(result.Layers[0].SBOM).NotTo(BeNil())
+				Expect(result.Layers[1].SBOM).To(BeNil())
+			})
+
+			it("links node_modules/.cache to the build cache layer through a path that exists at launch", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				tmpPath := filepath.Join(tempDir, npminstall.NODE_MODULES_CACHE)
+				link, err := os.Readlink(filepath.Join(layersDir, "build-modules", "node_modules", ".cache"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link).To(Equal(tmpPath))
+
+				link, err = os.Readlink(tmpPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(link).To(Equal(filepath.Join(layersDir, npminstall.LayerNameBuildCache)))
+			})
+
+			context("when a previous build created a launch layer", func() {
+				it.Before(func() {
+					Expect(os.MkdirAll(filepath.Join(layersDir, "launch-modules", "node_modules", "jest"), os.ModePerm)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(layersDir, "launch-modules.toml"), []byte("launch = true\n[metadata]\n  cache_sha = \"some-sha\"\n"), 0600)).To(Succeed())
+				})
+
+				it("empties it and returns it without flags", func() {
+					result, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+
+					launchLayer := result.Layers[1]
+					Expect(launchLayer.Launch).To(BeFalse())
+					Expect(launchLayer.Metadata).To(BeEmpty())
+					Expect(filepath.Join(launchLayer.Path, "node_modules", "jest")).NotTo(BeAnExistingFile())
+				})
+
+				context("when the build fails", func() {
+					it.Before(func() {
+						sbomGenerator.GenerateCall.Returns.Error = errors.New("failed to generate SBOM")
+					})
+
+					it("restores it", func() {
+						_, err := build(buildContext)
+						Expect(err).To(MatchError("failed to generate SBOM"))
+						Expect(filepath.Join(layersDir, "launch-modules", "node_modules", "jest")).To(BeADirectory())
+					})
+				})
+			})
+
+			context("when BP_KEEP_NODE_BUILD_CACHE is true", func() {
+				it.Before(func() {
+					environment.LookupCall.Stub = func(key string) (string, bool) {
+						if key == "BP_KEEP_NODE_BUILD_CACHE" {
+							return "true", true
+						}
+						return "", false
+					}
+				})
+
+				it("copies the build layer contents into the launch layer without pruning", func() {
+					result, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(pruneProcess.RunCall.CallCount).To(Equal(0))
+					Expect(buffer.String()).To(ContainSubstring("Skipping 'npm prune': the app has no devDependencies"))
+
+					Expect(result.Layers[0].Launch).To(BeFalse())
+
+					launchLayer := result.Layers[1]
+					Expect(launchLayer.Name).To(Equal("launch-modules"))
+					Expect(launchLayer.Launch).To(BeTrue())
+					Expect(filepath.Join(launchLayer.Path, "node_modules", "leftpad")).To(BeADirectory())
+				})
+			})
+
+			context("when the build layer SBOM cannot be generated", func() {
+				it.Before(func() {
+					sbomGenerator.GenerateCall.Stub = func(dir string) (sbom.SBOM, error) {
+						if sbomGenerator.GenerateCall.CallCount == 1 {
+							return sbom.SBOM{}, errors.New("failed to generate build SBOM")
+						}
+						return sbom.SBOM{}, nil
+					}
+				})
+
+				it("returns the error", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError("failed to generate build SBOM"))
+					Expect(filepath.Join(layersDir, "launch-modules")).NotTo(BeADirectory())
+				})
+			})
+
+			context("when the lockfile contains packages installed for development", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(filepath.Join(workingDir, "package-lock.json"), []byte(`{"lockfileVersion": 3, "packages": {"": {}, "node_modules/leftpad": {"version": "1.3.0", "dev": true}}}`), 0600)).To(Succeed())
+				})
+
+				it("prunes them", func() {
+					_, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(pruneProcess.RunCall.CallCount).To(Equal(1))
+				})
+			})
+
+			context("when package.json declares devDependencies", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(filepath.Join(workingDir, "package.json"), []byte(`{"devDependencies": {"jest": "^29.0.0"}}`), 0600)).To(Succeed())
+				})
+
+				it("prunes them", func() {
+					_, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(pruneProcess.RunCall.CallCount).To(Equal(1))
+				})
+
+				it("shares the files of the build layer with the node_modules that 'npm prune' prunes", func() {
+					buildProcess.RunCall.Stub = func(ld, cd, wd, rc, hp, a string, l bool) error {
+						Expect(os.MkdirAll(filepath.Join(ld, "node_modules", "jest"), os.ModePerm)).To(Succeed())
+						return os.WriteFile(filepath.Join(ld, "node_modules", "jest", "index.js"), []byte("module.exports = {}"), 0600)
+					}
+
+					var shared bool
+					pruneProcess.RunCall.Stub = func(ld, cd, wd, rc string, l bool) error {
+						buildFile, err := os.Stat(filepath.Join(layersDir, "build-modules", "node_modules", "jest", "index.js"))
+						if err != nil {
+							return err
+						}
+
+						pruneFile, err := os.Stat(filepath.Join(wd, "node_modules", "jest", "index.js"))
+						if err != nil {
+							return err
+						}
+
+						shared = os.SameFile(buildFile, pruneFile)
+						return nil
+					}
+
+					_, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+
+					// Where files can be cloned, they are cloned instead.
+					probe := filepath.Join(layersDir, "probe")
+					Expect(os.WriteFile(probe, nil, 0600)).To(Succeed())
+					reflinks := npminstall.NewTreeCopier(npminstall.CopyMethodReflink).Copy(probe, probe+"-clone") == nil
+					Expect(shared).To(Equal(!reflinks))
+				})
+
+				it("generates the build layer SBOM while 'npm prune' runs", func() {
+					pruned := filepath.Join(t.TempDir(), "pruned")
+					pruneProcess.RunCall.Stub = func(ld, cd, wd, rc string, l bool) error {
+						return os.WriteFile(pruned, nil, 0600)
+					}
+
+					var dirs []string
+					sbomGenerator.GenerateCall.Stub = func(dir string) (sbom.SBOM, error) {
+						dirs = append(dirs, dir)
+						if dir != filepath.Join(layersDir, "build-modules") {
+							return sbom.SBOM{}, nil
+						}
+
+						// The build layer SBOM only completes once the prune
+						// has run.
+						deadline := time.Now().Add(5 * time.Second)
+						for time.Now().Before(deadline) {
+							if _, err := os.Stat(pruned); err == nil {
+								return sbom.SBOM{}, nil
+							}
+							time.Sleep(10 * time.Millisecond)
+						}
+
+						return sbom.SBOM{}, errors.New("npm prune did not run concurrently")
+					}
+
+					result, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(dirs).To(ConsistOf(
+						filepath.Join(layersDir, "build-modules"),
+						filepath.Join(layersDir, "launch-modules"),
+					))
+
+					Expect(result.Layers[0].Name).To(Equal("build-modules"))
+					Expect(result.Layers[0].SBOM).NotTo(BeNil())
+					Expect(result.Layers[1].Name).To(Equal("launch-modules"))
+					Expect(result.Layers[1].SBOM).NotTo(BeNil())
+
+					Expect(buffer.String()).To(MatchRegexp(`(?s)Executing launch environment install process.*Generating SBOM for %s.*Generating SBOM for %s`,
+						regexp.QuoteMeta(filepath.Join(layersDir, "build-modules")),
+						regexp.QuoteMeta(filepath.Join(layersDir, "launch-modules")),
+					))
+				})
+			})
+
+			context("when a workspace declares devDependencies and there is no lockfile", func() {
+				it.Before(func() {
+					Expect(os.Remove(filepath.Join(workingDir, "package-lock.json"))).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(workingDir, "package.json"), []byte(`{"workspaces": {"packages": ["packages/*"]}}`), 0600)).To(Succeed())
+					Expect(os.MkdirAll(filepath.Join(workingDir, "packages", "some-workspace"), os.ModePerm)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(workingDir, "packages", "some-workspace", "package.json"), []byte(`{"devDependencies": {"jest": "^29.0.0"}}`), 0600)).To(Succeed())
+				})
+
+				it("prunes them", func() {
+					_, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(pruneProcess.RunCall.CallCount).To(Equal(1))
+				})
+			})
+
+			context("when a lockfileVersion 1 lockfile contains packages installed for development", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(filepath.Join(workingDir, "package-lock.json"), []byte(`{"lockfileVersion": 1, "dependencies": {"leftpad": {"version": "1.3.0", "dependencies": {"jest": {"version": "29.0.0", "dev": true}}}}}`), 0600)).To(Succeed())
+				})
+
+				it("prunes them", func() {
+					_, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(pruneProcess.RunCall.CallCount).To(Equal(1))
+				})
+			})
+
+			context("when package.json cannot be parsed", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(filepath.Join(workingDir, "package.json"), []byte("%%%"), 0600)).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := build(buildContext)
+					Expect(err).To(MatchError(ContainSubstring(`failed to parse "package.json"`)))
+				})
+			})
+		})
 	})
 
-	context("when one npmrc binding is detected", func() {
+	context("when the app vendors an npm-cache directory", func() {
 		it.Before(func() {
-			configurationManager.DeterminePathCall.Returns.Path = "some-binding-path/.npmrc"
-			entryResolver.MergeLayerTypesCall.Returns.Launch = true
+			buildManager.ResolveCall.Returns.Bool = true
+			Expect(os.MkdirAll(filepath.Join(workingDir, "npm-cache", "_cacache"), os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(workingDir, "npm-cache", "_cacache", "some-file"), nil, 0600)).To(Succeed())
 		})
 
-		it("passes the path to the .npmrc to the build process and env configuration", func() {
-			_, err := build(packit.BuildContext{
+		it("fingerprints it with the fingerprinter and merges it into the npm-cache layer", func() {
+			result, err := build(packit.BuildContext{
 				WorkingDir: workingDir,
 				Layers:     packit.Layers{Path: layersDir},
 				CNBPath:    cnbDir,
@@ -835,22 +1256,37 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 					},
 				},
 			})
-
 			Expect(err).NotTo(HaveOccurred())
-			Expect(buildProcess.ShouldRunCall.Receives.NpmrcPath).To(Equal("some-binding-path/.npmrc"))
-			Expect(buildProcess.RunCall.Receives.NpmrcPath).To(Equal("some-binding-path/.npmrc"))
+
+			Expect(fingerprinter.SumCall.Receives.Paths).To(Equal([]string{filepath.Join(workingDir, "npm-cache")}))
+			Expect(filepath.Join(workingDir, "npm-cache")).NotTo(BeAnExistingFile())
+			Expect(filepath.Join(layersDir, npminstall.LayerNameCache, "_cacache", "some-file")).To(BeARegularFile())
+
+			var cacheLayer packit.Layer
+			for _, layer := range result.Layers {
+				if layer.Name == npminstall.LayerNameCache {
+					cacheLayer = layer
+				}
+			}
+			Expect(cacheLayer.Metadata).To(HaveKeyWithValue("cache_sha", "some-npm-cache-sha"))
 		})
 	})
 
-	context("when the build process should not run", func() {
+	context("when npm generates a lockfile during a lockfile-less build", func() {
 		it.Before(func() {
-			buildProcess.ShouldRunCall.Returns.Run = false
 			entryResolver.MergeLayerTypesCall.Returns.Launch = true
-			Expect(os.MkdirAll(filepath.Join(workingDir, "node_modules"), os.ModePerm))
+			buildProcess.RunCall.Stub = func(ld, cd, wd, rc, hp, a string, l bool) error {
+				err := os.MkdirAll(filepath.Join(ld, "node_modules"), os.ModePerm)
+				if err != nil {
+					return err
+				}
+
+				return os.WriteFile(filepath.Join(wd, "package-lock.json"), []byte(`{"lockfileVersion": 3}`), 0600)
+			}
 		})
 
-		it("resolves and skips build process", func() {
-			_, err := build(packit.BuildContext{
+		it("exports the generated lockfile into the layer", func() {
+			result, err := build(packit.BuildContext{
 				WorkingDir: workingDir,
 				Layers:     packit.Layers{Path: layersDir},
 				CNBPath:    cnbDir,
@@ -862,25 +1298,32 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 			})
 			Expect(err).NotTo(HaveOccurred())
 
-			Expect(buildManager.ResolveCall.Receives.WorkingDir).To(Equal(workingDir))
-			Expect(buildProcess.RunCall.CallCount).To(Equal(0))
+			launchLayer := result.Layers[0]
+			Expect(launchLayer.Name).To(Equal("launch-modules"))
 
-			Expect(linker.LinkCall.Receives.Source).To(Equal(filepath.Join(workingDir, "node_modules")))
-			Expect(linker.LinkCall.Receives.Target).To(Equal(filepath.Join(layersDir, "launch-modules", "node_modules")))
+			content, err := os.ReadFile(filepath.Join(layersDir, "launch-modules", "package-lock.json"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(`{"lockfileVersion": 3}`))
+
+			Expect(launchLayer.Metadata).To(HaveKeyWithValue("cache_sha", "some-sha"))
+			Expect(launchLayer.Metadata).To(HaveKeyWithValue("generated_lockfile_sha", "some-lockfile-sha"))
+			Expect(summer.SumCall.Receives.Paths).To(Equal([]string{filepath.Join(layersDir, "launch-modules", "package-lock.json")}))
+
+			content, err = os.ReadFile(filepath.Join(workingDir, npminstall.GeneratedLockfileReport))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(content)).To(Equal(`{"lockfileVersion": 3}`))
+
+			Expect(buffer.String()).To(ContainSubstring("Warning: no package-lock.json was provided, dependency versions were resolved at build time"))
+			Expect(buffer.String()).To(ContainSubstring(fmt.Sprintf("Generated package-lock.json exported to %s and %s", filepath.Join(layersDir, "launch-modules", "package-lock.json"), filepath.Join(workingDir, npminstall.GeneratedLockfileReport))))
 		})
 
-		context("when BP_NODE_PROJECT_PATH is set", func() {
+		context("when a lockfile is provided", func() {
 			it.Before(func() {
-				buildProcess.ShouldRunCall.Returns.Run = true
-				t.Setenv("BP_NODE_PROJECT_PATH", "some-dir")
-				Expect(os.MkdirAll(filepath.Join(workingDir, "some-dir", "node_modules"), os.ModePerm))
+				Expect(os.WriteFile(filepath.Join(workingDir, "package-lock.json"), []byte(`{}`), 0600)).To(Succeed())
 			})
 
-			it("resolves and calls the build process", func() {
-				_, err := build(packit.BuildContext{
-					BuildpackInfo: packit.BuildpackInfo{
-						SBOMFormats: []string{"application/vnd.cyclonedx+json", "application/spdx+json", "application/vnd.syft+json"},
-					},
+			it("does not export the lockfile", func() {
+				result, err := build(packit.BuildContext{
 					WorkingDir: workingDir,
 					Layers:     packit.Layers{Path: layersDir},
 					CNBPath:    cnbDir,
@@ -892,30 +1335,680 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 				})
 				Expect(err).NotTo(HaveOccurred())
 
-				Expect(buildManager.ResolveCall.Receives.WorkingDir).To(Equal(filepath.Join(workingDir, "some-dir")))
-
-				Expect(processLayerDir).To(Equal(filepath.Join(layersDir, "launch-modules")))
-				Expect(processCacheDir).To(Equal(filepath.Join(layersDir, npminstall.LayerNameCache)))
-				Expect(processWorkingDir).To(Equal(filepath.Join(workingDir, "some-dir")))
+				Expect(filepath.Join(layersDir, "launch-modules", "package-lock.json")).NotTo(BeAnExistingFile())
+				Expect(filepath.Join(workingDir, npminstall.GeneratedLockfileReport)).NotTo(BeAnExistingFile())
+				Expect(result.Layers[0].Metadata).NotTo(HaveKey("generated_lockfile_sha"))
 			})
 		})
 
-	})
+		context("when the layer of a previous lockfile-less build is reused", func() {
+			var buildContext packit.BuildContext
 
-	context("when the cache layer directory is empty", func() {
-		it.Before(func() {
-			entryResolver.MergeLayerTypesCall.Returns.Launch = true
-			buildProcess.RunCall.Stub = func(ld, cd, wd, rc string, l bool) error {
-				err := os.MkdirAll(cd, os.ModePerm)
-				if err != nil {
-					return err
-				}
-				err = os.MkdirAll(filepath.Join(ld, "node_modules"), os.ModePerm)
-				if err != nil {
-					return err
-				}
+			it.Before(func() {
+				buildProcess.ShouldRunCall.Returns.Run = false
 
-				return nil
+				Expect(os.MkdirAll(filepath.Join(layersDir, "launch-modules", "node_modules"), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(layersDir, "launch-modules", "package-lock.json"), []byte(`{"lockfileVersion": 3}`), 0600)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(layersDir, "launch-modules.toml"), []byte("[metadata]\n  cache_sha = \"some-sha\"\n  generated_lockfile_sha = \"some-lockfile-sha\"\n"), 0600)).To(Succeed())
+
+				buildContext = packit.BuildContext{
+					WorkingDir: workingDir,
+					Layers:     packit.Layers{Path: layersDir},
+					CNBPath:    cnbDir,
+					Plan: packit.BuildpackPlan{
+						Entries: []packit.BuildpackPlanEntry{
+							{Name: "node_modules"},
+						},
+					},
+				}
+			})
+
+			it("verifies the stored lockfile against the layer metadata", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(buildProcess.RunCall.CallCount).To(Equal(0))
+				Expect(summer.SumCall.Receives.Paths).To(Equal([]string{filepath.Join(layersDir, "launch-modules", "package-lock.json")}))
+				Expect(buffer.String()).To(ContainSubstring(fmt.Sprintf("Reusing cached layer %s", filepath.Join(layersDir, "launch-modules"))))
+			})
+
+			it("exports the stored lockfile to the report path", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				content, err := os.ReadFile(filepath.Join(workingDir, npminstall.GeneratedLockfileReport))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(string(content)).To(Equal(`{"lockfileVersion": 3}`))
+
+				Expect(buffer.String()).To(ContainSubstring(fmt.Sprintf("Generated package-lock.json exported to %s", filepath.Join(workingDir, npminstall.GeneratedLockfileReport))))
+			})
+
+			context("when the build layer is reused", func() {
+				it.Before(func() {
+					entryResolver.MergeLayerTypesCall.Returns.Launch = false
+					entryResolver.MergeLayerTypesCall.Returns.Build = true
+
+					Expect(os.Rename(filepath.Join(layersDir, "launch-modules"), filepath.Join(layersDir, "build-modules"))).To(Succeed())
+					Expect(os.Rename(filepath.Join(layersDir, "launch-modules.toml"), filepath.Join(layersDir, "build-modules.toml"))).To(Succeed())
+				})
+
+				it("exports the stored lockfile to the report path", func() {
+					_, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(buildProcess.RunCall.CallCount).To(Equal(0))
+
+					content, err := os.ReadFile(filepath.Join(workingDir, npminstall.GeneratedLockfileReport))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(content)).To(Equal(`{"lockfileVersion": 3}`))
+				})
+			})
+
+			context("when the stored lockfile does not match the layer metadata", func() {
+				it.Before(func() {
+					summer.SumCall.Returns.String = "other-lockfile-sha"
+				})
+
+				it("installs the layer again", func() {
+					result, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(buildProcess.RunCall.CallCount).To(Equal(1))
+					Expect(buffer.String()).To(ContainSubstring(fmt.Sprintf("Generated package-lock.json in layer %s does not match its metadata", filepath.Join(layersDir, "launch-modules"))))
+
+					Expect(result.Layers[0].Metadata).To(HaveKeyWithValue("cache_sha", "some-sha"))
+					Expect(result.Layers[0].Metadata).To(HaveKeyWithValue("generated_lockfile_sha", "other-lockfile-sha"))
+				})
+			})
+
+			context("when the stored lockfile is missing", func() {
+				it.Before(func() {
+					Expect(os.Remove(filepath.Join(layersDir, "launch-modules", "package-lock.json"))).To(Succeed())
+				})
+
+				it("installs the layer again", func() {
+					_, err := build(buildContext)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(buildProcess.RunCall.CallCount).To(Equal(1))
+				})
+			})
+		})
+	})
+
+	context("when the installed native addons are verified against the target architecture", func() {
+		it.Before(func() {
+			entryResolver.MergeLayerTypesCall.Returns.Build = true
+			entryResolver.MergeLayerTypesCall.Returns.Launch = true
+
+			content, err := os.ReadFile(filepath.Join("testdata", "binaries", "linux-arm64.node"))
+			Expect(err).NotTo(HaveOccurred())
+
+			buildProcess.RunCall.Stub = func(ld, cd, wd, rc, hp, a string, l bool) error {
+				err := os.MkdirAll(filepath.Join(ld, "node_modules", "some-addon", "build", "Release"), os.ModePerm)
+				if err != nil {
+					return err
+				}
+
+				return os.WriteFile(filepath.Join(ld, "node_modules", "some-addon", "build", "Release", "addon.node"), content, 0644)
+			}
+
+			pruneProcess.RunCall.Stub = func(ld, cd, wd, rc string, l bool) error {
+				return os.MkdirAll(filepath.Join(wd, "node_modules"), os.ModePerm)
+			}
+		})
+
+		context("when they match", func() {
+			it("succeeds and passes the target architecture to the build process", func() {
+				_, err := build(packit.BuildContext{
+					WorkingDir: workingDir,
+					Layers:     packit.Layers{Path: layersDir},
+					CNBPath:    cnbDir,
+					TargetInfo: packit.TargetInfo{OS: "linux", Arch: "arm64"},
+					Plan: packit.BuildpackPlan{
+						Entries: []packit.BuildpackPlanEntry{
+							{Name: "node_modules"},
+						},
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(buildProcess.RunCall.Receives.Arch).To(Equal("arm64"))
+			})
+		})
+
+		context("when the target architecture is not known", func() {
+			it.Before(func() {
+				buildProcess.RunCall.Stub = func(ld, cd, wd, rc, hp, a string, l bool) error {
+					return os.MkdirAll(filepath.Join(ld, "node_modules"), os.ModePerm)
+				}
+			})
+
+			it("passes the architecture of the build container to the build process", func() {
+				_, err := build(packit.BuildContext{
+					WorkingDir: workingDir,
+					Layers:     packit.Layers{Path: layersDir},
+					CNBPath:    cnbDir,
+					Plan: packit.BuildpackPlan{
+						Entries: []packit.BuildpackPlanEntry{
+							{Name: "node_modules"},
+						},
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(buildProcess.RunCall.Receives.Arch).To(Equal(runtime.GOARCH))
+			})
+		})
+
+		context("when they do not match", func() {
+			it("returns an error listing the mismatched binaries", func() {
+				_, err := build(packit.BuildContext{
+					WorkingDir: workingDir,
+					Layers:     packit.Layers{Path: layersDir},
+					CNBPath:    cnbDir,
+					TargetInfo: packit.TargetInfo{OS: "linux", Arch: "amd64"},
+					Plan: packit.BuildpackPlan{
+						Entries: []packit.BuildpackPlanEntry{
+							{Name: "node_modules"},
+						},
+					},
+				})
+				Expect(err).To(MatchError(ContainSubstring("installed node_modules contain binaries that do not match the target architecture linux/amd64:")))
+				Expect(err).To(MatchError(ContainSubstring("  some-addon/build/Release/addon.node (ELF arm64)")))
+			})
+		})
+
+		context("when the launch modules do not match", func() {
+			it.Before(func() {
+				entryResolver.MergeLayerTypesCall.Returns.Build = false
+			})
+
+			it("returns an error listing the mismatched binaries", func() {
+				_, err := build(packit.BuildContext{
+					WorkingDir: workingDir,
+					Layers:     packit.Layers{Path: layersDir},
+					CNBPath:    cnbDir,
+					TargetInfo: packit.TargetInfo{OS: "linux", Arch: "amd64"},
+					Plan: packit.BuildpackPlan{
+						Entries: []packit.BuildpackPlanEntry{
+							{Name: "node_modules"},
+						},
+					},
+				})
+				Expect(err).To(MatchError(ContainSubstring("  some-addon/build/Release/addon.node (ELF arm64)")))
+				Expect(pruneProcess.RunCall.CallCount).To(Equal(0))
+			})
+		})
+
+		context("when a platform-specific package resolved for another architecture", func() {
+			it.Before(func() {
+				buildProcess.RunCall.Stub = func(ld, cd, wd, rc, hp, a string, l bool) error {
+					content, err := os.ReadFile(filepath.Join("testdata", "binaries", "linux-arm64.node"))
+					if err != nil {
+						return err
+					}
+
+					for path, mode := range map[string]os.FileMode{
+						"@swc/core-linux-arm64-gnu/swc.linux-arm64-gnu.node": 0644,
+						"@esbuild/linux-arm64/bin/esbuild":                   0755,
+					} {
+						path = filepath.Join(ld, "node_modules", path)
+						err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+						if err != nil {
+							return err
+						}
+
+						err = os.WriteFile(path, content, mode)
+						if err != nil {
+							return err
+						}
+					}
+
+					return nil
+				}
+			})
+
+			it("returns an error listing its addons and executables", func() {
+				_, err := build(packit.BuildContext{
+					WorkingDir: workingDir,
+					Layers:     packit.Layers{Path: layersDir},
+					CNBPath:    cnbDir,
+					TargetInfo: packit.TargetInfo{OS: "linux", Arch: "amd64"},
+					Plan: packit.BuildpackPlan{
+						Entries: []packit.BuildpackPlanEntry{
+							{Name: "node_modules"},
+						},
+					},
+				})
+				Expect(err).To(MatchError(ContainSubstring("installed node_modules contain binaries that do not match the target architecture linux/amd64:\n  @esbuild/linux-arm64/bin/esbuild (ELF arm64)\n  @swc/core-linux-arm64-gnu/swc.linux-arm64-gnu.node (ELF arm64)\ncheck")))
+			})
+		})
+
+		context("when a package ships prebuilt binaries for several platforms", func() {
+			var prebuilds map[string]string
+
+			it.Before(func() {
+				prebuilds = map[string]string{
+					"bufferutil/prebuilds/darwin-x64+arm64/node.napi.node": "darwin-arm64.node",
+					"bufferutil/prebuilds/win32-x64/node.napi.node":        "windows-amd64.exe",
+					"bufferutil/prebuilds/linux-arm64/node.napi.node":      "linux-arm64.node",
+					"bufferutil/prebuilds/linux-x64/node.napi.node":        "linux-amd64.node",
+					"7zip-bin/mac/arm64/7za":                               "darwin-arm64.node",
+					"7zip-bin/win/x64/7za.exe":                             "windows-amd64.exe",
+					"7zip-bin/linux/arm64/7za":                             "linux-arm64.node",
+					"7zip-bin/linux/x64/7za":                               "linux-amd64.node",
+				}
+
+				buildProcess.RunCall.Stub = func(ld, cd, wd, rc, hp, a string, l bool) error {
+					for path, fixture := range prebuilds {
+						content, err := os.ReadFile(filepath.Join("testdata", "binaries", fixture))
+						if err != nil {
+							return err
+						}
+
+						path = filepath.Join(ld, "node_modules", path)
+						err = os.MkdirAll(filepath.Dir(path), os.ModePerm)
+						if err != nil {
+							return err
+						}
+
+						err = os.WriteFile(path, content, 0755)
+						if err != nil {
+							return err
+						}
+					}
+
+					return nil
+				}
+			})
+
+			it("does not verify the binaries in directories named for other platforms", func() {
+				for _, arch := range []string{"amd64", "arm64"} {
+					_, err := build(packit.BuildContext{
+						WorkingDir: workingDir,
+						Layers:     packit.Layers{Path: layersDir},
+						CNBPath:    cnbDir,
+						TargetInfo: packit.TargetInfo{OS: "linux", Arch: arch},
+						Plan: packit.BuildpackPlan{
+							Entries: []packit.BuildpackPlanEntry{
+								{Name: "node_modules"},
+							},
+						},
+					})
+					Expect(err).NotTo(HaveOccurred(), arch)
+				}
+			})
+
+			context("when the prebuild for the target platform does not match", func() {
+				it.Before(func() {
+					prebuilds["bufferutil/prebuilds/linux-x64/node.napi.node"] = "linux-arm64.node"
+				})
+
+				it("returns an error listing the mismatched prebuild", func() {
+					_, err := build(packit.BuildContext{
+						WorkingDir: workingDir,
+						Layers:     packit.Layers{Path: layersDir},
+						CNBPath:    cnbDir,
+						TargetInfo: packit.TargetInfo{OS: "linux", Arch: "amd64"},
+						Plan: packit.BuildpackPlan{
+							Entries: []packit.BuildpackPlanEntry{
+								{Name: "node_modules"},
+							},
+						},
+					})
+					Expect(err).To(MatchError(ContainSubstring("installed node_modules contain binaries that do not match the target architecture linux/amd64:\n  bufferutil/prebuilds/linux-x64/node.napi.node (ELF arm64)\ncheck")))
+				})
+			})
+		})
+	})
+
+	context("when the build is interrupted", func() {
+		var buildContext packit.BuildContext
+
+		it.Before(func() {
+			entryResolver.MergeLayerTypesCall.Returns.Build = true
+
+			Expect(os.MkdirAll(filepath.Join(layersDir, "build-modules", "node_modules"), os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(layersDir, "build-modules.toml"), []byte("[metadata]\n  cache_sha = \"some-old-sha\"\n"), 0600)).To(Succeed())
+
+			buildContext = packit.BuildContext{
+				WorkingDir: workingDir,
+				Layers:     packit.Layers{Path: layersDir},
+				CNBPath:    cnbDir,
+				Plan: packit.BuildpackPlan{
+					Entries: []packit.BuildpackPlanEntry{
+						{Name: "node_modules"},
+					},
+				},
+			}
+		})
+
+		context("while npm is running", func() {
+			it.Before(func() {
+				buildProcess.RunCall.Stub = func(ld, cd, wd, rc, hp, a string, l bool) error {
+					Expect(os.MkdirAll(filepath.Join(wd, "node_modules", "some-package"), os.ModePerm)).To(Succeed())
+					Expect(os.MkdirAll(filepath.Join(ld, "node_modules", "some-package"), os.ModePerm)).To(Succeed())
+
+					interrupt.Trigger(syscall.SIGTERM)
+					return fmt.Errorf("npm ci failed: %w", interrupt.Err())
+				}
+			})
+
+			it("restores the previous layer and discards the partial node_modules", func() {
+				_, err := build(buildContext)
+				Expect(errors.Is(err, npminstall.ErrInterrupted)).To(BeTrue())
+				Expect(err).To(MatchError("npm ci failed: build interrupted by terminated"))
+
+				Expect(filepath.Join(layersDir, "build-modules", "node_modules")).To(BeADirectory())
+				Expect(filepath.Join(layersDir, "build-modules", "node_modules", "some-package")).NotTo(BeADirectory())
+				Expect(filepath.Join(layersDir, "build-modules.toml")).To(BeAnExistingFile())
+				Expect(filepath.Join(layersDir, "build-modules.previous")).NotTo(BeADirectory())
+				Expect(filepath.Join(workingDir, "node_modules")).NotTo(BeADirectory())
+
+				Expect(buffer.String()).To(ContainSubstring("Build interrupted, restoring the working directory"))
+			})
+		})
+
+		context("after vendored node_modules were moved into the layer", func() {
+			it.Before(func() {
+				Expect(os.MkdirAll(filepath.Join(workingDir, "node_modules", "some-vendored-package"), os.ModePerm)).To(Succeed())
+
+				buildProcess.RunCall.Stub = func(ld, cd, wd, rc, hp, a string, l bool) error {
+					err := os.Rename(filepath.Join(wd, "node_modules"), filepath.Join(ld, "node_modules"))
+					if err != nil {
+						return err
+					}
+
+					interrupt.Trigger(syscall.SIGINT)
+					return os.Symlink(filepath.Join(ld, "node_modules"), filepath.Join(wd, "node_modules"))
+				}
+			})
+
+			it("moves them back into the working directory", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("build interrupted by interrupt"))
+
+				Expect(filepath.Join(workingDir, "node_modules", "some-vendored-package")).To(BeADirectory())
+				info, err := os.Lstat(filepath.Join(workingDir, "node_modules"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(info.Mode() & os.ModeSymlink).To(BeZero())
+
+				Expect(filepath.Join(layersDir, "build-modules", "node_modules")).To(BeADirectory())
+				Expect(filepath.Join(layersDir, "build-modules", "node_modules", "some-vendored-package")).NotTo(BeADirectory())
+				Expect(linker.LinkCall.CallCount).To(Equal(0))
+			})
+		})
+
+		context("while pruning the launch modules", func() {
+			it.Before(func() {
+				entryResolver.MergeLayerTypesCall.Returns.Launch = true
+
+				pruneProcess.RunCall.Stub = func(ld, cd, wd, rc string, l bool) error {
+					interrupt.Trigger(syscall.SIGTERM)
+					return errors.New("npm prune failed: signal: terminated")
+				}
+			})
+
+			it("restores the layers installed during the build", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("build interrupted by terminated"))
+
+				Expect(filepath.Join(layersDir, "build-modules", "node_modules")).To(BeADirectory())
+				Expect(filepath.Join(layersDir, "build-modules.toml")).To(BeAnExistingFile())
+				Expect(filepath.Join(layersDir, "launch-modules")).NotTo(BeADirectory())
+				Expect(filepath.Join(layersDir, "launch-modules.toml")).NotTo(BeAnExistingFile())
+				Expect(filepath.Join(workingDir, "node_modules")).NotTo(BeADirectory())
+			})
+		})
+	})
+
+	context("when a layer was installed by a previous build", func() {
+		var buildContext packit.BuildContext
+
+		it.Before(func() {
+			entryResolver.MergeLayerTypesCall.Returns.Build = true
+
+			Expect(os.MkdirAll(filepath.Join(layersDir, "build-modules", "node_modules", "some-previous-package"), os.ModePerm)).To(Succeed())
+			Expect(os.WriteFile(filepath.Join(layersDir, "build-modules.toml"), []byte("[metadata]\n  cache_sha = \"some-old-sha\"\n"), 0600)).To(Succeed())
+
+			buildProcess.RunCall.Stub = func(ld, cd, wd, rc, hp, a string, l bool) error {
+				return os.MkdirAll(filepath.Join(ld, "node_modules", "some-new-package"), os.ModePerm)
+			}
+
+			buildContext = packit.BuildContext{
+				WorkingDir: workingDir,
+				Layers:     packit.Layers{Path: layersDir},
+				CNBPath:    cnbDir,
+				Plan: packit.BuildpackPlan{
+					Entries: []packit.BuildpackPlanEntry{
+						{Name: "node_modules"},
+					},
+				},
+			}
+		})
+
+		it("replaces its contents once the build succeeds", func() {
+			result, err := build(buildContext)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(result.Layers[0].Metadata).To(Equal(map[string]interface{}{"cache_sha": "some-sha"}))
+
+			Expect(filepath.Join(layersDir, "build-modules", "node_modules", "some-new-package")).To(BeADirectory())
+			Expect(filepath.Join(layersDir, "build-modules", "node_modules", "some-previous-package")).NotTo(BeADirectory())
+			Expect(filepath.Join(layersDir, "build-modules.previous")).NotTo(BeADirectory())
+		})
+
+		context("when a later step of the install fails", func() {
+			it.Before(func() {
+				sbomGenerator.GenerateCall.Returns.Error = errors.New("failed to generate SBOM")
+			})
+
+			it("keeps the previous contents of the layer", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("failed to generate SBOM"))
+
+				Expect(filepath.Join(layersDir, "build-modules", "node_modules", "some-previous-package")).To(BeADirectory())
+				Expect(filepath.Join(layersDir, "build-modules", "node_modules", "some-new-package")).NotTo(BeADirectory())
+				Expect(filepath.Join(layersDir, "build-modules.toml")).To(BeAnExistingFile())
+				Expect(filepath.Join(layersDir, "build-modules.previous")).NotTo(BeADirectory())
+			})
+		})
+
+		context("when the launch install fails", func() {
+			it.Before(func() {
+				entryResolver.MergeLayerTypesCall.Returns.Launch = true
+				pruneProcess.RunCall.Returns.Error = errors.New("failed to prune")
+			})
+
+			it("keeps the previous contents of the build layer as well", func() {
+				_, err := build(buildContext)
+				Expect(err).To(MatchError("failed to prune"))
+
+				Expect(filepath.Join(layersDir, "build-modules", "node_modules", "some-previous-package")).To(BeADirectory())
+				Expect(filepath.Join(layersDir, "build-modules", "node_modules", "some-new-package")).NotTo(BeADirectory())
+				Expect(filepath.Join(layersDir, "launch-modules")).NotTo(BeADirectory())
+			})
+		})
+
+		context("when a staging directory was left behind by a killed build", func() {
+			it.Before(func() {
+				Expect(os.MkdirAll(filepath.Join(layersDir, "build-modules.previous", "node_modules", "some-stale-package"), os.ModePerm)).To(Succeed())
+			})
+
+			it("removes it", func() {
+				_, err := build(buildContext)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(filepath.Join(layersDir, "build-modules.previous")).NotTo(BeADirectory())
+			})
+		})
+	})
+
+	context("when one npmrc binding is detected", func() {
+		it.Before(func() {
+			configurationManager.DeterminePathCall.Stub = func(typ, platformDir, entry string) (string, error) {
+				if typ == "npmrc" {
+					return "some-binding-path/.npmrc", nil
+				}
+				return "", nil
+			}
+			entryResolver.MergeLayerTypesCall.Returns.Launch = true
+		})
+
+		it("passes the path to the .npmrc to the build process and env configuration", func() {
+			_, err := build(packit.BuildContext{
+				WorkingDir: workingDir,
+				Layers:     packit.Layers{Path: layersDir},
+				CNBPath:    cnbDir,
+				Plan: packit.BuildpackPlan{
+					Entries: []packit.BuildpackPlanEntry{
+						{Name: "node_modules"},
+					},
+				},
+			})
+
+			Expect(err).NotTo(HaveOccurred())
+			Expect(buildProcess.ShouldRunCall.Receives.NpmrcPath).To(Equal("some-binding-path/.npmrc"))
+			Expect(buildProcess.RunCall.Receives.NpmrcPath).To(Equal("some-binding-path/.npmrc"))
+			Expect(buildProcess.RunCall.Receives.NodeHeadersPath).To(BeEmpty())
+		})
+
+		context("when NPM_CONFIG_GLOBALCONFIG is set", func() {
+			it.Before(func() {
+				environment.LookupCall.Stub = func(key string) (string, bool) {
+					if key == "NPM_CONFIG_GLOBALCONFIG" {
+						return "some-global-config", true
+					}
+					return "", false
+				}
+			})
+
+			it("passes that path instead of the binding", func() {
+				_, err := build(packit.BuildContext{
+					WorkingDir: workingDir,
+					Layers:     packit.Layers{Path: layersDir},
+					CNBPath:    cnbDir,
+					Plan: packit.BuildpackPlan{
+						Entries: []packit.BuildpackPlanEntry{
+							{Name: "node_modules"},
+						},
+					},
+				})
+
+				Expect(err).NotTo(HaveOccurred())
+				Expect(buildProcess.RunCall.Receives.NpmrcPath).To(Equal("some-global-config"))
+				Expect(buildProcess.RunCall.Receives.NodeHeadersPath).To(BeEmpty())
+			})
+		})
+	})
+
+	context("when a node-headers binding is detected", func() {
+		var requests []string
+
+		it.Before(func() {
+			requests = nil
+			configurationManager.DeterminePathCall.Stub = func(typ, platformDir, entry string) (string, error) {
+				requests = append(requests, fmt.Sprintf("%s %s %s", typ, platformDir, entry))
+				if typ == "node-headers" {
+					return "some-binding-path/node-headers.tar.gz", nil
+				}
+				return "", nil
+			}
+			entryResolver.MergeLayerTypesCall.Returns.Launch = true
+		})
+
+		it("points node-gyp at the headers tarball", func() {
+			_, err := build(packit.BuildContext{
+				WorkingDir: workingDir,
+				Layers:     packit.Layers{Path: layersDir},
+				CNBPath:    cnbDir,
+				Platform:   packit.Platform{Path: "some-platform-path"},
+				Plan: packit.BuildpackPlan{
+					Entries: []packit.BuildpackPlanEntry{
+						{Name: "node_modules"},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(requests).To(ContainElement("node-headers some-platform-path node-headers.tar.gz"))
+			Expect(buildProcess.RunCall.Receives.NodeHeadersPath).To(Equal("some-binding-path/node-headers.tar.gz"))
+			Expect(buildProcess.RunCall.Receives.NpmrcPath).To(BeEmpty())
+			Expect(os.Getenv("NPM_CONFIG_TARBALL")).To(BeEmpty())
+		})
+	})
+
+	context("when the build process should not run", func() {
+		it.Before(func() {
+			buildProcess.ShouldRunCall.Returns.Run = false
+			entryResolver.MergeLayerTypesCall.Returns.Launch = true
+			Expect(os.MkdirAll(filepath.Join(workingDir, "node_modules"), os.ModePerm))
+		})
+
+		it("resolves and skips build process", func() {
+			_, err := build(packit.BuildContext{
+				WorkingDir: workingDir,
+				Layers:     packit.Layers{Path: layersDir},
+				CNBPath:    cnbDir,
+				Plan: packit.BuildpackPlan{
+					Entries: []packit.BuildpackPlanEntry{
+						{Name: "node_modules"},
+					},
+				},
+			})
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(buildManager.ResolveCall.Receives.WorkingDir).To(Equal(workingDir))
+			Expect(buildProcess.RunCall.CallCount).To(Equal(0))
+
+			Expect(linker.LinkCall.Receives.Source).To(Equal(filepath.Join(workingDir, "node_modules")))
+			Expect(linker.LinkCall.Receives.Target).To(Equal(filepath.Join(layersDir, "launch-modules", "node_modules")))
+		})
+
+		context("when BP_NODE_PROJECT_PATH is set", func() {
+			it.Before(func() {
+				buildProcess.ShouldRunCall.Returns.Run = true
+				t.Setenv("BP_NODE_PROJECT_PATH", "some-dir")
+				Expect(os.MkdirAll(filepath.Join(workingDir, "some-dir", "node_modules"), os.ModePerm))
+			})
+
+			it("resolves and calls the build process", func() {
+				_, err := build(packit.BuildContext{
+					BuildpackInfo: packit.BuildpackInfo{
+						SBOMFormats: []string{"application/vnd.cyclonedx+json", "application/spdx+json", "application/vnd.syft+json"},
+					},
+					WorkingDir: workingDir,
+					Layers:     packit.Layers{Path: layersDir},
+					CNBPath:    cnbDir,
+					Plan: packit.BuildpackPlan{
+						Entries: []packit.BuildpackPlanEntry{
+							{Name: "node_modules"},
+						},
+					},
+				})
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(buildManager.ResolveCall.Receives.WorkingDir).To(Equal(filepath.Join(workingDir, "some-dir")))
+
+				Expect(processLayerDir).To(Equal(filepath.Join(layersDir, "launch-modules")))
+				Expect(processCacheDir).To(Equal(filepath.Join(layersDir, npminstall.LayerNameCache)))
+				Expect(processWorkingDir).To(Equal(filepath.Join(workingDir, "some-dir")))
+			})
+		})
+
+	})
+
+	context("when the cache layer directory is empty", func() {
+		it.Before(func() {
+			entryResolver.MergeLayerTypesCall.Returns.Launch = true
+			buildProcess.RunCall.Stub = func(ld, cd, wd, rc, hp, a string, l bool) error {
+				err := os.MkdirAll(cd, os.ModePerm)
+				if err != nil {
+					return err
+				}
+				err = os.MkdirAll(filepath.Join(ld, "node_modules"), os.ModePerm)
+				if err != nil {
+					return err
+				}
+
+				return nil
 			}
 		})
 
@@ -998,6 +2091,31 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 			})
 		})
 
+		context("when the node-headers binding cannot be resolved", func() {
+			it.Before(func() {
+				configurationManager.DeterminePathCall.Stub = func(typ, platformDir, entry string) (string, error) {
+					if typ == "node-headers" {
+						return "", errors.New("failed to determine headers path")
+					}
+					return "", nil
+				}
+			})
+
+			it("returns an error", func() {
+				_, err := build(packit.BuildContext{
+					WorkingDir: workingDir,
+					Layers:     packit.Layers{Path: layersDir},
+					CNBPath:    cnbDir,
+					Plan: packit.BuildpackPlan{
+						Entries: []packit.BuildpackPlanEntry{
+							{Name: "node_modules"},
+						},
+					},
+				})
+				Expect(err).To(MatchError("failed to determine headers path"))
+			})
+		})
+
 		context("when the project path parser provided fails", func() {
 			it.Before(func() {
 				t.Setenv("BP_NODE_PROJECT_PATH", "does_not_exist")
@@ -1018,6 +2136,27 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 			})
 		})
 
+		context("when the npm version resolver fails", func() {
+			it.Before(func() {
+				npmVersionResolver.ResolveCall.Returns.Err = errors.New("failed to resolve npm version")
+			})
+
+			it("returns an error", func() {
+				_, err := build(packit.BuildContext{
+					WorkingDir: workingDir,
+					Layers:     packit.Layers{Path: layersDir},
+					CNBPath:    cnbDir,
+					Plan: packit.BuildpackPlan{
+						Entries: []packit.BuildpackPlanEntry{
+							{Name: "node_modules"},
+						},
+					},
+				})
+				Expect(err).To(MatchError("failed to resolve npm version"))
+				Expect(npmVersionResolver.ResolveCall.Receives.ProjectPath).To(Equal(workingDir))
+			})
+		})
+
 		context("when the build process cannot be resolved", func() {
 			it.Before(func() {
 				buildManager.ResolveCall.Returns.Error = errors.New("failed to resolve build process")
@@ -1109,7 +2248,7 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 
 			context("when the build process provided fails", func() {
 				it.Before(func() {
-					buildProcess.RunCall.Stub = func(string, string, string, string, bool) error {
+					buildProcess.RunCall.Stub = func(string, string, string, string, string, string, bool) error {
 						return errors.New("given build process failed")
 					}
 				})
@@ -1295,7 +2434,7 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 			context("when build is also set and the node_modules copy fails", func() {
 				it.Before(func() {
 					entryResolver.MergeLayerTypesCall.Returns.Build = true
-					buildProcess.RunCall.Stub = func(string, string, string, string, bool) error {
+					buildProcess.RunCall.Stub = func(string, string, string, string, string, string, bool) error {
 						return nil
 					}
 				})
@@ -1317,7 +2456,7 @@ func testBuild(t *testing.T, context spec.G, it spec.S) {
 
 			context("when the build process provided fails", func() {
 				it.Before(func() {
-					buildProcess.RunCall.Stub = func(string, string, string, string, bool) error {
+					buildProcess.RunCall.Stub = func(string, string, string, string, string, string, bool) error {
 						return errors.New("given build process failed")
 					}
 				})
@@ -0,0 +1,73 @@
+package npminstall
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2/scribe"
+)
+
+type cacheInput struct {
+	name string
+	path string
+}
+
+// checkCacheInputs computes a digest for each of the given inputs and
+// compares the combined checksum against the "cache_sha" recorded in the
+// layer metadata. When they differ, it logs which inputs changed since the
+// previous build and returns the metadata to record on the rebuilt layer.
+func checkCacheInputs(summer Summer, logger scribe.Logger, metadata map[string]interface{}, inputs []cacheInput) (bool, map[string]interface{}, error) {
+	digests := map[string]interface{}{}
+	hash := sha256.New()
+	for _, input := range inputs {
+		sum, err := summer.Sum(input.path)
+		if err != nil {
+			return false, nil, err
+		}
+
+		digests[input.name] = sum
+		_, err = fmt.Fprintf(hash, "%s=%s\n", input.name, sum)
+		if err != nil {
+			return false, nil, err
+		}
+	}
+	sha := hex.EncodeToString(hash.Sum(nil))
+
+	cacheSha, ok := metadata["cache_sha"].(string)
+	if ok && sha == cacheSha {
+		return false, nil, nil
+	}
+
+	previous, ok := metadata["inputs"].(map[string]interface{})
+	if !ok {
+		logger.Subprocess("No cache inputs recorded by a previous build")
+		logger.Break()
+	} else {
+		var changed []string
+		for _, input := range inputs {
+			if previous[input.name] != digests[input.name] {
+				changed = append(changed, input.name)
+			}
+		}
+
+		var removed []string
+		for name := range previous {
+			if _, ok := digests[name]; !ok {
+				removed = append(removed, name)
+			}
+		}
+		sort.Strings(removed)
+		changed = append(changed, removed...)
+
+		logger.Subprocess("Cache invalidated by changes to: %s", strings.Join(changed, ", "))
+		logger.Break()
+	}
+
+	return true, map[string]interface{}{
+		"cache_sha": sha,
+		"inputs":    digests,
+	}, nil
+}
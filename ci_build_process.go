@@ -6,6 +6,7 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/paketo-buildpacks/packit/v2/fs"
 	"github.com/paketo-buildpacks/packit/v2/pexec"
@@ -16,19 +17,21 @@ type CIBuildProcess struct {
 	executable  Executable
 	summer      Summer
 	environment EnvironmentConfig
+	retryDelay  time.Duration
 	logger      scribe.Logger
 }
 
-func NewCIBuildProcess(executable Executable, summer Summer, environment EnvironmentConfig, logger scribe.Logger) CIBuildProcess {
+func NewCIBuildProcess(executable Executable, summer Summer, environment EnvironmentConfig, retryDelay time.Duration, logger scribe.Logger) CIBuildProcess {
 	return CIBuildProcess{
 		executable:  executable,
 		summer:      summer,
 		environment: environment,
+		retryDelay:  retryDelay,
 		logger:      logger,
 	}
 }
 
-func (r CIBuildProcess) ShouldRun(workingDir string, metadata map[string]interface{}, npmrcConfig string) (bool, string, error) {
+func (r CIBuildProcess) ShouldRun(workingDir string, metadata map[string]interface{}, npmrcConfig string) (bool, map[string]interface{}, error) {
 	cachedNodeVersion, err := cacheExecutableResponse(
 		r.executable,
 		[]string{"get", "user-agent"},
@@ -36,7 +39,7 @@ func (r CIBuildProcess) ShouldRun(workingDir string, metadata map[string]interfa
 		npmrcConfig,
 		r.logger)
 	if err != nil {
-		return false, "", fmt.Errorf("failed to execute npm get user-agent: %w", err)
+		return false, nil, fmt.Errorf("failed to execute npm get user-agent: %w", err)
 	}
 	defer func() {
 		if removeErr := os.Remove(cachedNodeVersion); removeErr != nil {
@@ -44,23 +47,25 @@ func (r CIBuildProcess) ShouldRun(workingDir string, metadata map[string]interfa
 		}
 	}()
 
-	sum, err := r.summer.Sum(
-		filepath.Join(workingDir, "package.json"),
-		filepath.Join(workingDir, "package-lock.json"),
-		cachedNodeVersion)
+	cachedNpmConfiguration, err := cacheNpmConfiguration(workingDir, npmrcConfig)
 	if err != nil {
-		return false, "", err
-	}
-
-	cacheSha, ok := metadata["cache_sha"].(string)
-	if !ok || sum != cacheSha {
-		return true, sum, nil
+		return false, nil, fmt.Errorf("failed to determine npm configuration: %w", err)
 	}
+	defer func() {
+		if removeErr := os.Remove(cachedNpmConfiguration); removeErr != nil {
+			r.logger.Subprocess("Warning: failed to remove temporary file %s: %s", cachedNpmConfiguration, removeErr)
+		}
+	}()
 
-	return false, "", nil
+	return checkCacheInputs(r.summer, r.logger, metadata, []cacheInput{
+		{name: "package.json", path: filepath.Join(workingDir, "package.json")},
+		{name: "package-lock.json", path: filepath.Join(workingDir, "package-lock.json")},
+		{name: "npm-user-agent", path: cachedNodeVersion},
+		{name: "npmrc", path: cachedNpmConfiguration},
+	})
 }
 
-func (r CIBuildProcess) Run(modulesDir, cacheDir, workingDir, npmrcPath string, launch bool) error {
+func (r CIBuildProcess) Run(modulesDir, cacheDir, workingDir, npmrcPath, nodeHeadersPath, arch string, launch bool) error {
 	err := os.MkdirAll(filepath.Join(workingDir, "node_modules"), os.ModePerm)
 	if err != nil {
 		return err
@@ -76,14 +81,53 @@ func (r CIBuildProcess) Run(modulesDir, cacheDir, workingDir, npmrcPath string,
 		environment = append(environment, fmt.Sprintf("NPM_CONFIG_GLOBALCONFIG=%s", npmrcPath))
 	}
 
+	// The headers are only required once npm has installed a package that is
+	// compiled by node-gyp.
+	nodeHome, _ := r.environment.Lookup("NODE_HOME")
+	headers, headersErr := resolveNodeHeaders(nodeHome, nodeHeadersPath)
+	if headersErr == nil {
+		environment = append(environment, headers.Env()...)
+	}
+
 	if !launch {
 		environment = append(environment, "NODE_ENV=development")
 	}
 
+	err = checkLockfileVersion(r.environment, filepath.Join(workingDir, "package-lock.json"))
+	if err != nil {
+		return err
+	}
+
+	requireLockfile, err := r.environment.LookupBool("BP_NPM_REQUIRE_LOCKFILE")
+	if err != nil {
+		return err
+	}
+
+	retries, err := lookupNpmRetries(r.environment)
+	if err != nil {
+		return err
+	}
+
+	cache, err := newNativeAddonCache(cacheDir, workingDir, nodeHome, arch)
+	if err != nil {
+		return err
+	}
+
+	// When the compiled output of every package with install scripts is
+	// cached, npm ci skips the install scripts and the output is restored
+	// instead of being compiled again.
+	restorable, err := cache.Restorable()
+	if err != nil {
+		return err
+	}
+
 	args := []string{"ci", "--unsafe-perm", "--cache", cacheDir}
+	if restorable != nil {
+		args = append(args, "--ignore-scripts")
+	}
 	r.logger.Subprocess("Running 'npm %s'", strings.Join(args, " "))
 
-	err = r.executable.Execute(pexec.Execution{
+	err = executeWithRetries(r.executable, r.logger, retries, r.retryDelay, pexec.Execution{
 		Args:   args,
 		Dir:    workingDir,
 		Stdout: r.logger.ActionWriter,
@@ -91,9 +135,29 @@ func (r CIBuildProcess) Run(modulesDir, cacheDir, workingDir, npmrcPath string,
 		Env:    environment,
 	})
 	if err != nil {
+		if requireLockfile && lockfileOutOfSync(err) {
+			return fmt.Errorf("lockfile policy violation: BP_NPM_REQUIRE_LOCKFILE is enabled but package-lock.json is out of sync with package.json: npm ci failed: %w", err)
+		}
 		return fmt.Errorf("npm ci failed: %w", err)
 	}
 
+	if restorable == nil {
+		err = checkNodeHeaders(headersErr, filepath.Join(workingDir, "node_modules"))
+		if err != nil {
+			return err
+		}
+	}
+
+	err = cache.RestoreInstalled(r.logger, restorable)
+	if err != nil {
+		return err
+	}
+
+	err = storeNativeAddons(r.logger, cache)
+	if err != nil {
+		return err
+	}
+
 	_, err = os.Stat(filepath.Join(workingDir, "node_modules"))
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -8,4 +8,9 @@ const (
 
 	LayerNameNodeModules = "modules"
 	LayerNameCache       = "npm-cache"
+	LayerNameBuildCache  = "node-build-cache"
+
+	// GeneratedLockfileReport is where the package-lock.json generated by a
+	// lockfile-less build is reported, relative to the working directory.
+	GeneratedLockfileReport = ".npm-install/generated-package-lock.json"
 )
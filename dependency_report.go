@@ -0,0 +1,141 @@
+package npminstall
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type dependencyProblem struct {
+	Kind    string
+	Name    string
+	Version string
+	Parent  string
+	Reason  string
+}
+
+func (p dependencyProblem) String() string {
+	description := fmt.Sprintf("%s: %s@%s", p.Kind, p.Name, p.Version)
+	if p.Reason != "" {
+		description = fmt.Sprintf("%s (%s)", description, p.Reason)
+	}
+
+	if p.Kind != "extraneous" {
+		description = fmt.Sprintf("%s, required by %s", description, p.Parent)
+	}
+
+	return description
+}
+
+type dependencyTree struct {
+	Name         string                    `json:"name"`
+	Version      string                    `json:"version"`
+	Required     json.RawMessage           `json:"required"`
+	Missing      bool                      `json:"missing"`
+	Invalid      json.RawMessage           `json:"invalid"`
+	Extraneous   bool                      `json:"extraneous"`
+	Dependencies map[string]dependencyTree `json:"dependencies"`
+}
+
+// parseDependencyReport walks the output of "npm ls --json --all" and returns
+// the missing, invalid and extraneous packages along with the package that
+// requires them.
+func parseDependencyReport(content []byte) ([]dependencyProblem, error) {
+	var root dependencyTree
+	err := json.Unmarshal(content, &root)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse npm ls output: %w", err)
+	}
+
+	parent := root.Name
+	if root.Version != "" {
+		parent = fmt.Sprintf("%s@%s", root.Name, root.Version)
+	}
+
+	return collectDependencyProblems(parent, root.Dependencies), nil
+}
+
+func collectDependencyProblems(parent string, dependencies map[string]dependencyTree) []dependencyProblem {
+	var names []string
+	for name := range dependencies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	var problems []dependencyProblem
+	for _, name := range names {
+		dependency := dependencies[name]
+
+		switch {
+		case dependency.Missing:
+			problems = append(problems, dependencyProblem{
+				Kind:    "missing",
+				Name:    name,
+				Version: requiredVersion(dependency.Required),
+				Parent:  parent,
+			})
+		case isInvalid(dependency.Invalid):
+			problems = append(problems, dependencyProblem{
+				Kind:    "invalid",
+				Name:    name,
+				Version: dependency.Version,
+				Parent:  parent,
+				Reason:  invalidReason(dependency.Invalid),
+			})
+		case dependency.Extraneous:
+			problems = append(problems, dependencyProblem{
+				Kind:    "extraneous",
+				Name:    name,
+				Version: dependency.Version,
+				Parent:  parent,
+			})
+		}
+
+		problems = append(problems, collectDependencyProblems(fmt.Sprintf("%s@%s", name, dependency.Version), dependency.Dependencies)...)
+	}
+
+	return problems
+}
+
+// requiredVersion handles both the npm 7+ format, where "required" is the
+// requested version range, and the legacy format, where it is an object.
+func requiredVersion(required json.RawMessage) string {
+	var version string
+	if err := json.Unmarshal(required, &version); err == nil {
+		return version
+	}
+
+	var legacy struct {
+		Version string `json:"version"`
+	}
+	if err := json.Unmarshal(required, &legacy); err == nil {
+		return legacy.Version
+	}
+
+	return ""
+}
+
+// isInvalid handles both the npm 7+ format, where "invalid" describes the
+// expected version range, and the legacy boolean format.
+func isInvalid(invalid json.RawMessage) bool {
+	if len(invalid) == 0 {
+		return false
+	}
+
+	var flag bool
+	if err := json.Unmarshal(invalid, &flag); err == nil {
+		return flag
+	}
+
+	return true
+}
+
+func invalidReason(invalid json.RawMessage) string {
+	var reason string
+	if err := json.Unmarshal(invalid, &reason); err == nil {
+		return strings.TrimSpace(reason)
+	}
+
+	return ""
+}
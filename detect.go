@@ -2,11 +2,14 @@ package npminstall
 
 import (
 	"errors"
+	"fmt"
 	"os"
 	"path/filepath"
+	"strconv"
 
 	"github.com/paketo-buildpacks/libnodejs"
 	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/fs"
 )
 
 type BuildPlanMetadata struct {
@@ -21,7 +24,7 @@ type VersionParser interface {
 	ParseVersion(path string) (version string, err error)
 }
 
-func Detect() packit.DetectFunc {
+func Detect(environment EnvironmentConfig) packit.DetectFunc {
 	return func(context packit.DetectContext) (packit.DetectResult, error) {
 		var requirements []packit.BuildPlanRequirement
 
@@ -57,13 +60,21 @@ func Detect() packit.DetectFunc {
 
 		requirements = append(requirements, nodeDependency)
 
-		bpNpmIncludeBuildPython, bpNpmIncludeBuildPythonExists := os.LookupEnv("BP_NPM_INCLUDE_BUILD_PYTHON")
-
-		installPython := false
-		if bpNpmIncludeBuildPythonExists && (bpNpmIncludeBuildPython == "" || bpNpmIncludeBuildPython == "true") {
+		var installPython bool
+		bpNpmIncludeBuildPython, found := environment.Lookup("BP_NPM_INCLUDE_BUILD_PYTHON")
+		switch {
+		case found && bpNpmIncludeBuildPython == "":
 			installPython = true
-		} else if bpNpmIncludeBuildPythonExists && bpNpmIncludeBuildPython == "false" {
-			installPython = false
+		case found:
+			installPython, err = strconv.ParseBool(bpNpmIncludeBuildPython)
+			if err != nil {
+				return packit.DetectResult{}, fmt.Errorf("failed to parse boolean environment variable %q: %w", "BP_NPM_INCLUDE_BUILD_PYTHON", err)
+			}
+		default:
+			installPython, err = requiresBuildPython(projectPath)
+			if err != nil {
+				return packit.DetectResult{}, err
+			}
 		}
 
 		if installPython {
@@ -93,3 +104,76 @@ func Detect() packit.DetectFunc {
 		}, nil
 	}
 }
+
+// requiresBuildPython inspects the package-lock.json for packages with
+// install scripts that compile native code using node-gyp, either because
+// they depend on node-gyp or because they ship a binding.gyp file in the
+// vendored node_modules. Lockfiles of lockfileVersion 1 do not record install
+// scripts, so every package in their dependency tree is inspected.
+func requiresBuildPython(projectPath string) (bool, error) {
+	lockfilePath := filepath.Join(projectPath, "package-lock.json")
+	exists, err := fs.Exists(lockfilePath)
+	if err != nil {
+		return false, err
+	}
+
+	if !exists {
+		return false, nil
+	}
+
+	lockfile, err := parseLockfile(lockfilePath)
+	if err != nil {
+		return false, err
+	}
+
+	if len(lockfile.Packages) == 0 {
+		return dependenciesRequireBuildPython(projectPath, "", lockfile.Dependencies)
+	}
+
+	for path, pkg := range lockfile.Packages {
+		if !pkg.HasInstallScript {
+			continue
+		}
+
+		if _, ok := pkg.Dependencies["node-gyp"]; ok {
+			return true, nil
+		}
+
+		exists, err := fs.Exists(filepath.Join(projectPath, path, "binding.gyp"))
+		if err != nil {
+			return false, err
+		}
+
+		if exists {
+			return true, nil
+		}
+	}
+
+	return false, nil
+}
+
+func dependenciesRequireBuildPython(projectPath, parent string, dependencies map[string]LockfileDependency) (bool, error) {
+	for name, dependency := range dependencies {
+		path := filepath.Join(parent, "node_modules", name)
+
+		if _, ok := dependency.Requires["node-gyp"]; ok {
+			return true, nil
+		}
+
+		exists, err := fs.Exists(filepath.Join(projectPath, path, "binding.gyp"))
+		if err != nil {
+			return false, err
+		}
+
+		if exists {
+			return true, nil
+		}
+
+		required, err := dependenciesRequireBuildPython(projectPath, path, dependency.Dependencies)
+		if err != nil || required {
+			return required, err
+		}
+	}
+
+	return false, nil
+}
@@ -6,6 +6,7 @@ import (
 	"testing"
 
 	npminstall "github.com/paketo-buildpacks/npm-install"
+	"github.com/paketo-buildpacks/npm-install/fakes"
 	"github.com/paketo-buildpacks/packit/v2"
 	"github.com/sclevine/spec"
 
@@ -16,9 +17,10 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 	var (
 		Expect = NewWithT(t).Expect
 
-		detect     packit.DetectFunc
-		filePath   string
-		workingDir string
+		detect      packit.DetectFunc
+		filePath    string
+		workingDir  string
+		environment *fakes.EnvironmentConfig
 	)
 
 	it.Before(func() {
@@ -32,7 +34,9 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 
 		t.Setenv("BP_NODE_PROJECT_PATH", "")
 
-		detect = npminstall.Detect()
+		environment = &fakes.EnvironmentConfig{}
+
+		detect = npminstall.Detect(environment)
 	})
 
 	it("returns a plan that provides node_modules", func() {
@@ -116,12 +120,9 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 			}`), 0600)).To(Succeed())
 		})
 
-		it.After(func() {
-			Expect(os.Unsetenv("BP_NPM_INCLUDE_BUILD_PYTHON")).To(Succeed())
-		})
-
 		it("has been set to true, it should include cpython buildpack", func() {
-			Expect(os.Setenv("BP_NPM_INCLUDE_BUILD_PYTHON", "true")).To(Succeed())
+			environment.LookupCall.Returns.Value = "true"
+			environment.LookupCall.Returns.Found = true
 			result, err := detect(packit.DetectContext{
 				WorkingDir: workingDir,
 			})
@@ -156,7 +157,8 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 		})
 
 		it("has no value, it should include cpython buildpack", func() {
-			Expect(os.Setenv("BP_NPM_INCLUDE_BUILD_PYTHON", "")).To(Succeed())
+			environment.LookupCall.Returns.Value = ""
+			environment.LookupCall.Returns.Found = true
 
 			result, err := detect(packit.DetectContext{
 				WorkingDir: workingDir,
@@ -191,7 +193,8 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 		})
 
 		it("has been set to false, it does not include cpython buildpack", func() {
-			Expect(os.Setenv("BP_NPM_INCLUDE_BUILD_PYTHON", "false")).To(Succeed())
+			environment.LookupCall.Returns.Value = "false"
+			environment.LookupCall.Returns.Found = true
 
 			result, err := detect(packit.DetectContext{
 				WorkingDir: workingDir,
@@ -217,15 +220,16 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 				},
 			}))
 		})
+	})
 
-		it("has been set to a random string, it does not include cpython buildpack", func() {
-			Expect(os.Setenv("BP_NPM_INCLUDE_BUILD_PYTHON", "random-string")).To(Succeed())
+	context("when the package-lock.json contains packages that compile native code", func() {
+		var planWithPython packit.BuildPlan
 
-			result, err := detect(packit.DetectContext{
-				WorkingDir: workingDir,
-			})
-			Expect(err).NotTo(HaveOccurred())
-			Expect(result.Plan).To(Equal(packit.BuildPlan{
+		it.Before(func() {
+			Expect(os.WriteFile(filePath, []byte(`{
+			}`), 0600)).To(Succeed())
+
+			planWithPython = packit.BuildPlan{
 				Provides: []packit.BuildPlanProvision{
 					{Name: npminstall.NodeModules},
 				},
@@ -236,6 +240,13 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 							Build: true,
 						},
 					},
+					{
+						Name: npminstall.Cpython,
+						Metadata: npminstall.BuildPlanMetadata{
+							Build:  true,
+							Launch: false,
+						},
+					},
 					{
 						Name: npminstall.Npm,
 						Metadata: npminstall.BuildPlanMetadata{
@@ -243,7 +254,147 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 						},
 					},
 				},
-			}))
+			}
+		})
+
+		context("when a package with an install script depends on node-gyp", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "package-lock.json"), []byte(`{
+					"lockfileVersion": 3,
+					"packages": {
+						"node_modules/bcrypt": {
+							"hasInstallScript": true,
+							"dependencies": {
+								"node-gyp": "^9.0.0"
+							}
+						}
+					}
+				}`), 0600)).To(Succeed())
+			})
+
+			it("includes the cpython buildpack", func() {
+				result, err := detect(packit.DetectContext{
+					WorkingDir: workingDir,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Plan).To(Equal(planWithPython))
+			})
+
+			context("when $BP_NPM_INCLUDE_BUILD_PYTHON is set to false", func() {
+				it.Before(func() {
+					environment.LookupCall.Returns.Value = "false"
+					environment.LookupCall.Returns.Found = true
+				})
+
+				it("does not include the cpython buildpack", func() {
+					result, err := detect(packit.DetectContext{
+						WorkingDir: workingDir,
+					})
+					Expect(err).NotTo(HaveOccurred())
+					Expect(result.Plan.Requires).NotTo(ContainElement(HaveField("Name", npminstall.Cpython)))
+				})
+			})
+		})
+
+		context("when a package with an install script ships a binding.gyp", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "package-lock.json"), []byte(`{
+					"lockfileVersion": 3,
+					"packages": {
+						"node_modules/sqlite3": {
+							"hasInstallScript": true
+						}
+					}
+				}`), 0600)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(workingDir, "node_modules", "sqlite3"), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(workingDir, "node_modules", "sqlite3", "binding.gyp"), nil, 0600)).To(Succeed())
+			})
+
+			it("includes the cpython buildpack", func() {
+				result, err := detect(packit.DetectContext{
+					WorkingDir: workingDir,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Plan).To(Equal(planWithPython))
+			})
+		})
+
+		context("when a lockfileVersion 1 dependency requires node-gyp", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "package-lock.json"), []byte(`{
+					"lockfileVersion": 1,
+					"dependencies": {
+						"bcrypt": {
+							"version": "5.1.0",
+							"requires": {
+								"node-gyp": "^9.0.0"
+							}
+						}
+					}
+				}`), 0600)).To(Succeed())
+			})
+
+			it("includes the cpython buildpack", func() {
+				result, err := detect(packit.DetectContext{
+					WorkingDir: workingDir,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Plan).To(Equal(planWithPython))
+			})
+		})
+
+		context("when a nested lockfileVersion 1 dependency ships a binding.gyp", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "package-lock.json"), []byte(`{
+					"lockfileVersion": 1,
+					"dependencies": {
+						"some-package": {
+							"version": "1.0.0",
+							"dependencies": {
+								"sqlite3": {
+									"version": "5.1.6"
+								}
+							}
+						}
+					}
+				}`), 0600)).To(Succeed())
+				Expect(os.MkdirAll(filepath.Join(workingDir, "node_modules", "some-package", "node_modules", "sqlite3"), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(workingDir, "node_modules", "some-package", "node_modules", "sqlite3", "binding.gyp"), nil, 0600)).To(Succeed())
+			})
+
+			it("includes the cpython buildpack", func() {
+				result, err := detect(packit.DetectContext{
+					WorkingDir: workingDir,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Plan).To(Equal(planWithPython))
+			})
+		})
+
+		context("when packages with install scripts do not compile native code", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "package-lock.json"), []byte(`{
+					"lockfileVersion": 3,
+					"packages": {
+						"node_modules/esbuild": {
+							"hasInstallScript": true
+						},
+						"node_modules/some-gyp-user": {
+							"dependencies": {
+								"node-gyp": "^9.0.0"
+							}
+						}
+					}
+				}`), 0600)).To(Succeed())
+			})
+
+			it("does not include the cpython buildpack", func() {
+				result, err := detect(packit.DetectContext{
+					WorkingDir: workingDir,
+				})
+				Expect(err).NotTo(HaveOccurred())
+				Expect(result.Plan.Requires).NotTo(ContainElement(HaveField("Name", npminstall.Cpython)))
+			})
 		})
 	})
 
@@ -261,6 +412,34 @@ func testDetect(t *testing.T, context spec.G, it spec.S) {
 			})
 		})
 
+		context("when $BP_NPM_INCLUDE_BUILD_PYTHON is not a boolean", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filePath, []byte(`{}`), 0600)).To(Succeed())
+				environment.LookupCall.Returns.Value = "random-string"
+				environment.LookupCall.Returns.Found = true
+			})
+
+			it("returns an error", func() {
+				_, err := detect(packit.DetectContext{
+					WorkingDir: workingDir,
+				})
+				Expect(err).To(MatchError(ContainSubstring(`failed to parse boolean environment variable "BP_NPM_INCLUDE_BUILD_PYTHON"`)))
+			})
+		})
+
+		context("when the package-lock.json cannot be parsed", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "package-lock.json"), []byte(`%%%`), 0600)).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := detect(packit.DetectContext{
+					WorkingDir: workingDir,
+				})
+				Expect(err).To(MatchError(ContainSubstring(`failed to parse "package-lock.json"`)))
+			})
+		})
+
 		context("when the project path parser fails", func() {
 			it.Before(func() {
 				t.Setenv("BP_NODE_PROJECT_PATH", "does_not_exist")
@@ -0,0 +1,142 @@
+package npminstall
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/paketo-buildpacks/packit/v2/fs"
+)
+
+type devDependenciesManifest struct {
+	DevDependencies map[string]string `json:"devDependencies"`
+	Workspaces      json.RawMessage   `json:"workspaces"`
+}
+
+// hasDevDependencies reports whether 'npm prune' could remove anything from
+// the node_modules of the given project: the package.json of the project or
+// of one of its workspaces declares devDependencies, or the package-lock.json
+// contains packages that are only installed for development. A missing
+// package.json is treated as declaring devDependencies so that the prune is
+// never skipped by accident.
+func hasDevDependencies(projectPath string) (bool, error) {
+	pkg, err := readDevDependenciesManifest(filepath.Join(projectPath, "package.json"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return true, nil
+		}
+		return false, err
+	}
+
+	if len(pkg.DevDependencies) > 0 {
+		return true, nil
+	}
+
+	workspaces, err := workspacePaths(projectPath, pkg.Workspaces)
+	if err != nil {
+		return false, err
+	}
+
+	for _, workspace := range workspaces {
+		workspacePkg, err := readDevDependenciesManifest(filepath.Join(workspace, "package.json"))
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				continue
+			}
+			return false, err
+		}
+
+		if len(workspacePkg.DevDependencies) > 0 {
+			return true, nil
+		}
+	}
+
+	lockfilePath := filepath.Join(projectPath, "package-lock.json")
+	exists, err := fs.Exists(lockfilePath)
+	if err != nil {
+		return false, err
+	}
+
+	if !exists {
+		return false, nil
+	}
+
+	lockfile, err := parseLockfile(lockfilePath)
+	if err != nil {
+		return false, err
+	}
+
+	for _, pkg := range lockfile.Packages {
+		if pkg.Dev || pkg.DevOptional {
+			return true, nil
+		}
+	}
+
+	return hasDevLockfileDependency(lockfile.Dependencies), nil
+}
+
+func readDevDependenciesManifest(path string) (devDependenciesManifest, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return devDependenciesManifest{}, err
+		}
+		return devDependenciesManifest{}, fmt.Errorf(`failed to read "package.json": %w`, err)
+	}
+
+	var pkg devDependenciesManifest
+	err = json.Unmarshal(content, &pkg)
+	if err != nil {
+		return devDependenciesManifest{}, fmt.Errorf(`failed to parse "package.json": %w`, err)
+	}
+
+	return pkg, nil
+}
+
+// workspacePaths expands the "workspaces" of a package.json, given either as
+// a list of globs or as an object holding them in "packages", into the
+// directories of the workspaces.
+func workspacePaths(projectPath string, workspaces json.RawMessage) ([]string, error) {
+	if len(workspaces) == 0 {
+		return nil, nil
+	}
+
+	var patterns []string
+	err := json.Unmarshal(workspaces, &patterns)
+	if err != nil {
+		var object struct {
+			Packages []string `json:"packages"`
+		}
+
+		err = json.Unmarshal(workspaces, &object)
+		if err != nil {
+			return nil, fmt.Errorf(`failed to parse "package.json": invalid workspaces: %w`, err)
+		}
+		patterns = object.Packages
+	}
+
+	var paths []string
+	for _, pattern := range patterns {
+		matches, err := filepath.Glob(filepath.Join(projectPath, pattern))
+		if err != nil {
+			return nil, fmt.Errorf(`failed to parse "package.json": invalid workspace %q: %w`, pattern, err)
+		}
+		paths = append(paths, matches...)
+	}
+
+	return paths, nil
+}
+
+// hasDevLockfileDependency walks the nested dependency tree of a
+// lockfileVersion 1 lockfile.
+func hasDevLockfileDependency(dependencies map[string]LockfileDependency) bool {
+	for _, dependency := range dependencies {
+		if dependency.Dev || hasDevLockfileDependency(dependency.Dependencies) {
+			return true
+		}
+	}
+
+	return false
+}
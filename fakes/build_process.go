@@ -7,16 +7,18 @@ type BuildProcess struct {
 		mutex     sync.Mutex
 		CallCount int
 		Receives  struct {
-			ModulesDir string
-			CacheDir   string
-			WorkingDir string
-			NpmrcPath  string
-			Launch     bool
+			ModulesDir      string
+			CacheDir        string
+			WorkingDir      string
+			NpmrcPath       string
+			NodeHeadersPath string
+			Arch            string
+			Launch          bool
 		}
 		Returns struct {
 			Error error
 		}
-		Stub func(string, string, string, string, bool) error
+		Stub func(string, string, string, string, string, string, bool) error
 	}
 	ShouldRunCall struct {
 		mutex     sync.Mutex
@@ -28,16 +30,18 @@ type BuildProcess struct {
 			NpmrcPath string
 		}
 		Returns struct {
-			Run bool
-			Sha string
+			Run           bool
+			LayerMetadata map[string]interface {
+			}
 			Err error
 		}
 		Stub func(string, map[string]interface {
-		}, string) (bool, string, error)
+		}, string) (bool, map[string]interface {
+		}, error)
 	}
 }
 
-func (f *BuildProcess) Run(param1 string, param2 string, param3 string, param4 string, param5 bool) error {
+func (f *BuildProcess) Run(param1 string, param2 string, param3 string, param4 string, param5 string, param6 string, param7 bool) error {
 	f.RunCall.mutex.Lock()
 	defer f.RunCall.mutex.Unlock()
 	f.RunCall.CallCount++
@@ -45,14 +49,17 @@ func (f *BuildProcess) Run(param1 string, param2 string, param3 string, param4 s
 	f.RunCall.Receives.CacheDir = param2
 	f.RunCall.Receives.WorkingDir = param3
 	f.RunCall.Receives.NpmrcPath = param4
-	f.RunCall.Receives.Launch = param5
+	f.RunCall.Receives.NodeHeadersPath = param5
+	f.RunCall.Receives.Arch = param6
+	f.RunCall.Receives.Launch = param7
 	if f.RunCall.Stub != nil {
-		return f.RunCall.Stub(param1, param2, param3, param4, param5)
+		return f.RunCall.Stub(param1, param2, param3, param4, param5, param6, param7)
 	}
 	return f.RunCall.Returns.Error
 }
 func (f *BuildProcess) ShouldRun(param1 string, param2 map[string]interface {
-}, param3 string) (bool, string, error) {
+}, param3 string) (bool, map[string]interface {
+}, error) {
 	f.ShouldRunCall.mutex.Lock()
 	defer f.ShouldRunCall.mutex.Unlock()
 	f.ShouldRunCall.CallCount++
@@ -62,5 +69,5 @@ func (f *BuildProcess) ShouldRun(param1 string, param2 map[string]interface {
 	if f.ShouldRunCall.Stub != nil {
 		return f.ShouldRunCall.Stub(param1, param2, param3)
 	}
-	return f.ShouldRunCall.Returns.Run, f.ShouldRunCall.Returns.Sha, f.ShouldRunCall.Returns.Err
+	return f.ShouldRunCall.Returns.Run, f.ShouldRunCall.Returns.LayerMetadata, f.ShouldRunCall.Returns.Err
 }
@@ -0,0 +1,29 @@
+package fakes
+
+import "sync"
+
+type NpmVersionResolver struct {
+	ResolveCall struct {
+		mutex     sync.Mutex
+		CallCount int
+		Receives  struct {
+			ProjectPath string
+		}
+		Returns struct {
+			Version string
+			Err     error
+		}
+		Stub func(string) (string, error)
+	}
+}
+
+func (f *NpmVersionResolver) Resolve(param1 string) (string, error) {
+	f.ResolveCall.mutex.Lock()
+	defer f.ResolveCall.mutex.Unlock()
+	f.ResolveCall.CallCount++
+	f.ResolveCall.Receives.ProjectPath = param1
+	if f.ResolveCall.Stub != nil {
+		return f.ResolveCall.Stub(param1)
+	}
+	return f.ResolveCall.Returns.Version, f.ResolveCall.Returns.Err
+}
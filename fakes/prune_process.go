@@ -28,12 +28,14 @@ type PruneProcess struct {
 			NpmrcPath string
 		}
 		Returns struct {
-			Run bool
-			Sha string
+			Run           bool
+			LayerMetadata map[string]interface {
+			}
 			Err error
 		}
 		Stub func(string, map[string]interface {
-		}, string) (bool, string, error)
+		}, string) (bool, map[string]interface {
+		}, error)
 	}
 }
 
@@ -52,7 +54,8 @@ func (f *PruneProcess) Run(param1 string, param2 string, param3 string, param4 s
 	return f.RunCall.Returns.Error
 }
 func (f *PruneProcess) ShouldRun(param1 string, param2 map[string]interface {
-}, param3 string) (bool, string, error) {
+}, param3 string) (bool, map[string]interface {
+}, error) {
 	f.ShouldRunCall.mutex.Lock()
 	defer f.ShouldRunCall.mutex.Unlock()
 	f.ShouldRunCall.CallCount++
@@ -62,5 +65,5 @@ func (f *PruneProcess) ShouldRun(param1 string, param2 map[string]interface {
 	if f.ShouldRunCall.Stub != nil {
 		return f.ShouldRunCall.Stub(param1, param2, param3)
 	}
-	return f.ShouldRunCall.Returns.Run, f.ShouldRunCall.Returns.Sha, f.ShouldRunCall.Returns.Err
+	return f.ShouldRunCall.Returns.Run, f.ShouldRunCall.Returns.LayerMetadata, f.ShouldRunCall.Returns.Err
 }
@@ -0,0 +1,167 @@
+package npminstall
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2/fs"
+)
+
+// FingerprintMode selects how a Fingerprinter fingerprints the files within a
+// directory.
+type FingerprintMode string
+
+const (
+	// FingerprintModeManifest fingerprints a directory by the paths, sizes
+	// and modes of its files together with the full content of the files
+	// that describe packages, i.e. package.json files, which hold the
+	// version and integrity of installed packages, the hidden lockfile of
+	// node_modules and the index of an npm cache.
+	FingerprintModeManifest FingerprintMode = "manifest"
+
+	// FingerprintModeContent fingerprints a directory by the full content of
+	// all of its files.
+	FingerprintModeContent FingerprintMode = "content"
+)
+
+// LookupFingerprintMode returns the mode configured through
+// BP_NPM_FINGERPRINT_MODE, defaulting to FingerprintModeManifest.
+func LookupFingerprintMode(environment EnvironmentConfig) (FingerprintMode, error) {
+	value, ok := environment.Lookup("BP_NPM_FINGERPRINT_MODE")
+	if !ok || value == "" {
+		return FingerprintModeManifest, nil
+	}
+
+	switch mode := FingerprintMode(value); mode {
+	case FingerprintModeManifest, FingerprintModeContent:
+		return mode, nil
+	default:
+		return "", fmt.Errorf("invalid value for BP_NPM_FINGERPRINT_MODE %q: must be %q or %q", value, FingerprintModeManifest, FingerprintModeContent)
+	}
+}
+
+// Fingerprinter computes a digest of files and directories that changes
+// whenever their contents change, without necessarily reading every byte of
+// large directories such as node_modules or an npm cache. Files that are
+// given directly are always fingerprinted by their full content.
+type Fingerprinter struct {
+	mode FingerprintMode
+}
+
+func NewFingerprinter(mode FingerprintMode) Fingerprinter {
+	return Fingerprinter{
+		mode: mode,
+	}
+}
+
+func (f Fingerprinter) Sum(paths ...string) (string, error) {
+	if f.mode == FingerprintModeContent {
+		return fs.NewChecksumCalculator().Sum(paths...)
+	}
+
+	hash := sha256.New()
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return "", fmt.Errorf("failed to calculate fingerprint: %w", err)
+		}
+
+		if !info.IsDir() {
+			sum, err := fileSum(path)
+			if err != nil {
+				return "", fmt.Errorf("failed to calculate fingerprint: %w", err)
+			}
+
+			_, err = fmt.Fprintf(hash, "file %s\n", sum)
+			if err != nil {
+				return "", err
+			}
+
+			continue
+		}
+
+		err = writeManifest(hash, path)
+		if err != nil {
+			return "", fmt.Errorf("failed to calculate fingerprint: %w", err)
+		}
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+// writeManifest writes a line for every entry of the directory. Paths are
+// relative to the directory so that moving it elsewhere does not change the
+// fingerprint.
+func writeManifest(w io.Writer, dir string) error {
+	return filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case info.Mode()&os.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			_, err = fmt.Fprintf(w, "link %q %q\n", rel, link)
+			return err
+
+		case info.IsDir():
+			_, err = fmt.Fprintf(w, "dir %q %s\n", rel, info.Mode())
+			return err
+
+		case info.Mode().IsRegular() && describesPackages(rel):
+			sum, err := fileSum(path)
+			if err != nil {
+				return err
+			}
+
+			_, err = fmt.Fprintf(w, "file %q %s %s\n", rel, info.Mode(), sum)
+			return err
+
+		default:
+			_, err = fmt.Fprintf(w, "file %q %s %d\n", rel, info.Mode(), info.Size())
+			return err
+		}
+	})
+}
+
+// describesPackages reports whether the file at the given relative path holds
+// package versions or integrity values. Entries in the content store of an
+// npm cache need not be read, as their paths are derived from their integrity.
+func describesPackages(rel string) bool {
+	switch filepath.Base(rel) {
+	case "package.json", ".package-lock.json":
+		return true
+	}
+
+	return strings.HasPrefix(rel, filepath.Join("_cacache", "index-v5")+string(filepath.Separator))
+}
+
+func fileSum(path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	_, err = io.Copy(hash, file)
+	if err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
@@ -0,0 +1,183 @@
+package npminstall_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	npminstall "github.com/paketo-buildpacks/npm-install"
+	"github.com/paketo-buildpacks/npm-install/fakes"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testFingerprinter(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		nodeModules   string
+		fingerprinter npminstall.Fingerprinter
+	)
+
+	it.Before(func() {
+		nodeModules = filepath.Join(t.TempDir(), "node_modules")
+
+		Expect(os.MkdirAll(filepath.Join(nodeModules, "leftpad", "lib"), os.ModePerm)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(nodeModules, "leftpad", "package.json"), []byte(`{"version": "1.0.0"}`), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(nodeModules, "leftpad", "lib", "index.js"), []byte("module.exports = 1"), 0644)).To(Succeed())
+
+		fingerprinter = npminstall.NewFingerprinter(npminstall.FingerprintModeManifest)
+	})
+
+	sum := func(paths ...string) string {
+		sum, err := fingerprinter.Sum(paths...)
+		Expect(err).NotTo(HaveOccurred())
+		return sum
+	}
+
+	context("Sum", func() {
+		it("does not depend on the location of the directory", func() {
+			moved := filepath.Join(t.TempDir(), "node_modules")
+			Expect(os.Rename(nodeModules, moved)).To(Succeed())
+			previous := sum(moved)
+
+			Expect(os.Rename(moved, nodeModules)).To(Succeed())
+			Expect(sum(nodeModules)).To(Equal(previous))
+		})
+
+		it("changes when a package version changes", func() {
+			previous := sum(nodeModules)
+			Expect(os.WriteFile(filepath.Join(nodeModules, "leftpad", "package.json"), []byte(`{"version": "1.0.1"}`), 0644)).To(Succeed())
+			Expect(sum(nodeModules)).NotTo(Equal(previous))
+		})
+
+		it("changes when the size of a file changes", func() {
+			previous := sum(nodeModules)
+			Expect(os.WriteFile(filepath.Join(nodeModules, "leftpad", "lib", "index.js"), []byte("module.exports = 10"), 0644)).To(Succeed())
+			Expect(sum(nodeModules)).NotTo(Equal(previous))
+		})
+
+		it("changes when the mode of a file changes", func() {
+			previous := sum(nodeModules)
+			Expect(os.Chmod(filepath.Join(nodeModules, "leftpad", "lib", "index.js"), 0755)).To(Succeed())
+			Expect(sum(nodeModules)).NotTo(Equal(previous))
+		})
+
+		it("changes when a file is added", func() {
+			previous := sum(nodeModules)
+			Expect(os.WriteFile(filepath.Join(nodeModules, "leftpad", "README.md"), nil, 0644)).To(Succeed())
+			Expect(sum(nodeModules)).NotTo(Equal(previous))
+		})
+
+		it("does not read files that do not describe packages", func() {
+			previous := sum(nodeModules)
+			Expect(os.WriteFile(filepath.Join(nodeModules, "leftpad", "lib", "index.js"), []byte("module.exports = 2"), 0644)).To(Succeed())
+			Expect(sum(nodeModules)).To(Equal(previous))
+		})
+
+		context("when given a file", func() {
+			it("fingerprints its content", func() {
+				path := filepath.Join(nodeModules, "leftpad", "lib", "index.js")
+				previous := sum(path)
+				Expect(os.WriteFile(path, []byte("module.exports = 2"), 0644)).To(Succeed())
+				Expect(sum(path)).NotTo(Equal(previous))
+			})
+		})
+
+		context("when the mode is content", func() {
+			it.Before(func() {
+				fingerprinter = npminstall.NewFingerprinter(npminstall.FingerprintModeContent)
+			})
+
+			it("reads every file", func() {
+				previous := sum(nodeModules)
+				Expect(os.WriteFile(filepath.Join(nodeModules, "leftpad", "lib", "index.js"), []byte("module.exports = 2"), 0644)).To(Succeed())
+				Expect(sum(nodeModules)).NotTo(Equal(previous))
+			})
+		})
+
+		context("failure cases", func() {
+			context("when the path does not exist", func() {
+				it("returns an error", func() {
+					_, err := fingerprinter.Sum(filepath.Join(nodeModules, "missing"))
+					Expect(err).To(MatchError(ContainSubstring("failed to calculate fingerprint")))
+				})
+			})
+		})
+	})
+
+	context("LookupFingerprintMode", func() {
+		var environment *fakes.EnvironmentConfig
+
+		it.Before(func() {
+			environment = &fakes.EnvironmentConfig{}
+		})
+
+		it("defaults to manifest", func() {
+			mode, err := npminstall.LookupFingerprintMode(environment)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(mode).To(Equal(npminstall.FingerprintModeManifest))
+		})
+
+		context("when set to content", func() {
+			it.Before(func() {
+				environment.LookupCall.Returns.Value = "content"
+				environment.LookupCall.Returns.Found = true
+			})
+
+			it("returns the content mode", func() {
+				mode, err := npminstall.LookupFingerprintMode(environment)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(mode).To(Equal(npminstall.FingerprintModeContent))
+			})
+		})
+
+		context("when the value is invalid", func() {
+			it.Before(func() {
+				environment.LookupCall.Returns.Value = "some-mode"
+				environment.LookupCall.Returns.Found = true
+			})
+
+			it("returns an error", func() {
+				_, err := npminstall.LookupFingerprintMode(environment)
+				Expect(err).To(MatchError(`invalid value for BP_NPM_FINGERPRINT_MODE "some-mode": must be "manifest" or "content"`))
+			})
+		})
+	})
+}
+
+func BenchmarkFingerprinter(b *testing.B) {
+	nodeModules := filepath.Join(b.TempDir(), "node_modules")
+	content := bytes.Repeat([]byte("x"), 64*1024)
+	for i := 0; i < 200; i++ {
+		dir := filepath.Join(nodeModules, fmt.Sprintf("package-%d", i))
+		if err := os.MkdirAll(filepath.Join(dir, "lib"), os.ModePerm); err != nil {
+			b.Fatal(err)
+		}
+
+		if err := os.WriteFile(filepath.Join(dir, "package.json"), []byte(fmt.Sprintf(`{"version": "1.0.%d"}`, i)), 0644); err != nil {
+			b.Fatal(err)
+		}
+
+		for j := 0; j < 20; j++ {
+			if err := os.WriteFile(filepath.Join(dir, "lib", fmt.Sprintf("file-%d.js", j)), content, 0644); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	for _, mode := range []npminstall.FingerprintMode{npminstall.FingerprintModeManifest, npminstall.FingerprintModeContent} {
+		b.Run(string(mode), func(b *testing.B) {
+			fingerprinter := npminstall.NewFingerprinter(mode)
+			for i := 0; i < b.N; i++ {
+				_, err := fingerprinter.Sum(nodeModules)
+				if err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package npminstall
+
+import (
+	"bytes"
+	"debug/elf"
+	"debug/macho"
+	"debug/pe"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+type foreignBinary struct {
+	Path        string
+	Package     string
+	PackagePath string
+	Format      string
+	Arch        string
+}
+
+func (b foreignBinary) String() string {
+	return fmt.Sprintf("%s (%s %s)", b.Path, b.Format, b.Arch)
+}
+
+var elfMachines = map[string]elf.Machine{
+	"386":     elf.EM_386,
+	"amd64":   elf.EM_X86_64,
+	"arm":     elf.EM_ARM,
+	"arm64":   elf.EM_AARCH64,
+	"ppc64le": elf.EM_PPC64,
+	"s390x":   elf.EM_S390,
+}
+
+// nonLinuxPlatforms lists the names packages use for directories holding
+// binaries for platforms other than linux, e.g. prebuilds/darwin-x64 or
+// 7zip-bin/mac.
+var nonLinuxPlatforms = map[string]bool{
+	"aix":     true,
+	"android": true,
+	"darwin":  true,
+	"freebsd": true,
+	"mac":     true,
+	"macos":   true,
+	"openbsd": true,
+	"osx":     true,
+	"sunos":   true,
+	"win":     true,
+	"win32":   true,
+	"windows": true,
+}
+
+// archAliases maps the names packages use for architectures in directory
+// names to Go architectures.
+var archAliases = map[string]string{
+	"386":     "386",
+	"ia32":    "386",
+	"x86":     "386",
+	"amd64":   "amd64",
+	"x64":     "amd64",
+	"x86_64":  "amd64",
+	"arm":     "arm",
+	"armv7":   "arm",
+	"armv7l":  "arm",
+	"arm64":   "arm64",
+	"aarch64": "arm64",
+	"ppc64":   "ppc64le",
+	"ppc64le": "ppc64le",
+	"s390x":   "s390x",
+}
+
+// findForeignBinaries walks the given node_modules directory and returns the
+// native addons and executables that cannot run on linux/<arch>: Mach-O and
+// PE binaries as well as ELF binaries built for another architecture. Every
+// .node file and every file with an executable bit is inspected, except for
+// those in directories of a package that are named for another platform or
+// architecture, such as prebuilds/darwin-x64 or linux/arm64, which packages
+// shipping binaries for several platforms never load on linux/<arch>. Paths
+// are reported relative to the node_modules directory.
+func findForeignBinaries(nodeModulesPath, arch string) ([]foreignBinary, error) {
+	var binaries []foreignBinary
+	err := filepath.WalkDir(nodeModulesPath, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		if filepath.Ext(path) != ".node" {
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+
+			if info.Mode().Perm()&0111 == 0 {
+				return nil
+			}
+		}
+
+		rel, err := filepath.Rel(nodeModulesPath, path)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+
+		name, packageDir := owningPackage(rel)
+		if otherPlatformPath(strings.TrimPrefix(rel, packageDir+"/"), arch) {
+			return nil
+		}
+
+		format, binaryArch, foreign, err := inspectBinary(path, arch)
+		if err != nil {
+			return err
+		}
+
+		if foreign {
+			binaries = append(binaries, foreignBinary{
+				Path:        rel,
+				Package:     name,
+				PackagePath: filepath.Join(nodeModulesPath, filepath.FromSlash(packageDir)),
+				Format:      format,
+				Arch:        binaryArch,
+			})
+		}
+
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed to scan node_modules for native binaries: %w", err)
+	}
+
+	sort.Slice(binaries, func(i, j int) bool {
+		return binaries[i].Path < binaries[j].Path
+	})
+
+	return binaries, nil
+}
+
+// otherPlatformPath reports whether a directory of the given path within its
+// package is named for a platform other than linux or an architecture other
+// than arch, e.g. prebuilds/win32-x64, prebuilds/linux-arm64 or mac/x64.
+// Directories naming several architectures, such as darwin-x64+arm64, match
+// if any of them is the target.
+func otherPlatformPath(path, arch string) bool {
+	segments := strings.Split(path, "/")
+	for _, segment := range segments[:len(segments)-1] {
+		tokens := strings.Split(strings.ToLower(segment), "-")
+		if nonLinuxPlatforms[tokens[0]] {
+			return true
+		}
+
+		var archs string
+		switch {
+		case tokens[0] == "linux" && len(tokens) > 1:
+			archs = tokens[1]
+		case len(tokens) == 1:
+			archs = tokens[0]
+		default:
+			continue
+		}
+
+		var named, matches bool
+		for _, name := range strings.Split(archs, "+") {
+			if goArch, ok := archAliases[name]; ok {
+				named = true
+				matches = matches || goArch == arch
+			}
+		}
+
+		if named && !matches {
+			return true
+		}
+	}
+
+	return false
+}
+
+// inspectBinary identifies the binary format and architecture of the file at
+// the given path. Files that are not ELF, Mach-O or PE binaries are never
+// reported as foreign.
+func inspectBinary(path, arch string) (string, string, bool, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", false, err
+	}
+	defer file.Close()
+
+	magic := make([]byte, 4)
+	_, err = io.ReadFull(file, magic)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return "", "", false, nil
+		}
+		return "", "", false, err
+	}
+
+	switch {
+	case bytes.Equal(magic, []byte(elf.ELFMAG)):
+		binary, err := elf.NewFile(file)
+		if err != nil {
+			return "", "", false, nil
+		}
+
+		expected, known := elfMachines[arch]
+		return "ELF", elfArch(binary.Machine), known && binary.Machine != expected, nil
+
+	case bytes.HasPrefix(magic, []byte("MZ")):
+		binary, err := pe.NewFile(file)
+		if err != nil {
+			return "", "", false, nil
+		}
+
+		return "PE", peArch(binary.Machine), true, nil
+
+	default:
+		if binary, err := macho.NewFile(file); err == nil {
+			return "Mach-O", machoArch(binary.Cpu), true, nil
+		}
+
+		if binary, err := macho.NewFatFile(file); err == nil {
+			var archs []string
+			for _, fatArch := range binary.Arches {
+				archs = append(archs, machoArch(fatArch.Cpu))
+			}
+			return "Mach-O universal", strings.Join(archs, ","), true, nil
+		}
+	}
+
+	return "", "", false, nil
+}
+
+// owningPackage returns the name and directory of the innermost package that
+// contains the given path relative to node_modules, e.g. "@scope/name" and
+// "a/node_modules/@scope/name" for
+// "a/node_modules/@scope/name/build/Release/addon.node".
+func owningPackage(path string) (string, string) {
+	segments := strings.Split(path, "/")
+
+	var name, dir string
+	for i := 0; i < len(segments)-1; i++ {
+		if i > 0 && segments[i-1] != "node_modules" {
+			continue
+		}
+
+		name, dir = segments[i], strings.Join(segments[:i+1], "/")
+		if strings.HasPrefix(name, "@") && i+2 < len(segments) {
+			name = fmt.Sprintf("%s/%s", name, segments[i+1])
+			dir = strings.Join(segments[:i+2], "/")
+		}
+	}
+
+	return name, dir
+}
+
+func elfArch(machine elf.Machine) string {
+	for arch, m := range elfMachines {
+		if m == machine {
+			return arch
+		}
+	}
+
+	return machine.String()
+}
+
+func machoArch(cpu macho.Cpu) string {
+	switch cpu {
+	case macho.Cpu386:
+		return "386"
+	case macho.CpuAmd64:
+		return "amd64"
+	case macho.CpuArm:
+		return "arm"
+	case macho.CpuArm64:
+		return "arm64"
+	}
+
+	return cpu.String()
+}
+
+func peArch(machine uint16) string {
+	switch machine {
+	case pe.IMAGE_FILE_MACHINE_I386:
+		return "386"
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		return "amd64"
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		return "arm64"
+	}
+
+	return fmt.Sprintf("0x%x", machine)
+}
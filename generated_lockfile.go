@@ -0,0 +1,113 @@
+package npminstall
+
+import (
+	"os"
+	"path/filepath"
+
+	"github.com/paketo-buildpacks/packit/v2/fs"
+	"github.com/paketo-buildpacks/packit/v2/scribe"
+)
+
+// exportGeneratedLockfile copies the package-lock.json that npm generated
+// during a lockfile-less build into the given layer and to the report path so
+// that the resolved dependency versions can be reproduced. The lockfile
+// checksum is recorded in the returned layer metadata.
+func exportGeneratedLockfile(logger scribe.Emitter, summer Summer, projectPath, layerPath, reportPath string, metadata map[string]interface{}) (map[string]interface{}, error) {
+	lockfilePath := filepath.Join(projectPath, "package-lock.json")
+	exists, err := fs.Exists(lockfilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return metadata, nil
+	}
+
+	destination := filepath.Join(layerPath, "package-lock.json")
+	err = fs.Copy(lockfilePath, destination)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeGeneratedLockfileReport(lockfilePath, reportPath)
+	if err != nil {
+		return nil, err
+	}
+
+	sum, err := summer.Sum(destination)
+	if err != nil {
+		return nil, err
+	}
+
+	if metadata == nil {
+		metadata = map[string]interface{}{}
+	}
+	metadata["generated_lockfile_sha"] = sum
+
+	logger.Subprocess("Warning: no package-lock.json was provided, dependency versions were resolved at build time")
+	logger.Action("Generated package-lock.json exported to %s and %s", destination, reportPath)
+
+	return metadata, nil
+}
+
+// generatedLockfileChanged reports whether the package-lock.json that npm
+// generated when the layer was installed no longer matches the checksum
+// recorded in the layer metadata. A layer that fails this check does not hold
+// the dependency versions it claims and is installed again.
+func generatedLockfileChanged(summer Summer, layerPath string, metadata map[string]interface{}) (bool, error) {
+	expected, ok := metadata["generated_lockfile_sha"].(string)
+	if !ok {
+		return false, nil
+	}
+
+	lockfilePath := filepath.Join(layerPath, "package-lock.json")
+	exists, err := fs.Exists(lockfilePath)
+	if err != nil {
+		return false, err
+	}
+
+	if !exists {
+		return true, nil
+	}
+
+	sum, err := summer.Sum(lockfilePath)
+	if err != nil {
+		return false, err
+	}
+
+	return sum != expected, nil
+}
+
+// reportGeneratedLockfile copies the package-lock.json that npm generated when
+// a reused layer was installed to the report path, so that an image built from
+// the reused layer reports the same dependency versions.
+func reportGeneratedLockfile(logger scribe.Emitter, layerPath, reportPath string) error {
+	lockfilePath := filepath.Join(layerPath, "package-lock.json")
+	exists, err := fs.Exists(lockfilePath)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return nil
+	}
+
+	err = writeGeneratedLockfileReport(lockfilePath, reportPath)
+	if err != nil {
+		return err
+	}
+
+	logger.Subprocess("Warning: no package-lock.json was provided, dependency versions were resolved when the layer was installed")
+	logger.Action("Generated package-lock.json exported to %s", reportPath)
+
+	return nil
+}
+
+func writeGeneratedLockfileReport(lockfilePath, reportPath string) error {
+	err := os.MkdirAll(filepath.Dir(reportPath), os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	return fs.Copy(lockfilePath, reportPath)
+}
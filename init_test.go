@@ -15,14 +15,19 @@ func TestUnitNPMInstall(t *testing.T) {
 	suite("Build", testBuild)
 	suite("BuildProcessResolver", testBuildProcessResolver)
 	suite("CIBuildProcess", testCIBuildProcess)
+	suite("CollectNpmCacheGarbage", testCollectNpmCacheGarbage)
 	suite("Detect", testDetect)
 	suite("Environment", testEnvironment)
+	suite("Fingerprinter", testFingerprinter)
 	suite("InstallBuildProcess", testInstallBuildProcess)
 	suite("LinkedModuleResolver", testLinkedModuleResolver)
 	suite("Linker", testLinker)
+	suite("NpmEngineResolver", testNpmEngineResolver)
+	suite("NpmExecutable", testNpmExecutable)
 	suite("PackageManangerConfigurationManager", testPackageManagerConfigurationManager)
 	suite("PruneBuildProcess", testPruneBuildProcess)
 	suite("RebuildBuildProcess", testRebuildBuildProcess)
+	suite("TreeCopier", testTreeCopier)
 	suite("UpdateNpmCacheLayer", testUpdateNpmCache)
 	suite.Run(t)
 }
@@ -6,31 +6,65 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"time"
 
 	"github.com/paketo-buildpacks/packit/v2/fs"
 	"github.com/paketo-buildpacks/packit/v2/pexec"
 	"github.com/paketo-buildpacks/packit/v2/scribe"
 )
 
-func NewInstallBuildProcess(executable Executable, environment EnvironmentConfig, logger scribe.Logger) InstallBuildProcess {
+func NewInstallBuildProcess(executable Executable, summer Summer, environment EnvironmentConfig, retryDelay time.Duration, logger scribe.Logger) InstallBuildProcess {
 	return InstallBuildProcess{
 		executable:  executable,
+		summer:      summer,
 		environment: environment,
+		retryDelay:  retryDelay,
 		logger:      logger,
 	}
 }
 
 type InstallBuildProcess struct {
 	executable  Executable
+	summer      Summer
 	environment EnvironmentConfig
+	retryDelay  time.Duration
 	logger      scribe.Logger
 }
 
-func (r InstallBuildProcess) ShouldRun(workingDir string, metadata map[string]interface{}, npmrcPath string) (bool, string, error) {
-	return true, "", nil
+func (r InstallBuildProcess) ShouldRun(workingDir string, metadata map[string]interface{}, npmrcPath string) (bool, map[string]interface{}, error) {
+	cachedNodeVersion, err := cacheExecutableResponse(
+		r.executable,
+		[]string{"get", "user-agent"},
+		workingDir,
+		npmrcPath,
+		r.logger)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to execute npm get user-agent: %w", err)
+	}
+	defer func() {
+		if removeErr := os.Remove(cachedNodeVersion); removeErr != nil {
+			r.logger.Subprocess("Warning: failed to remove temporary file %s: %s", cachedNodeVersion, removeErr)
+		}
+	}()
+
+	cachedNpmConfiguration, err := cacheNpmConfiguration(workingDir, npmrcPath)
+	if err != nil {
+		return false, nil, fmt.Errorf("failed to determine npm configuration: %w", err)
+	}
+	defer func() {
+		if removeErr := os.Remove(cachedNpmConfiguration); removeErr != nil {
+			r.logger.Subprocess("Warning: failed to remove temporary file %s: %s", cachedNpmConfiguration, removeErr)
+		}
+	}()
+
+	return checkCacheInputs(r.summer, r.logger, metadata, []cacheInput{
+		{name: "package.json", path: filepath.Join(workingDir, "package.json")},
+		{name: "npm-user-agent", path: cachedNodeVersion},
+		{name: "npmrc", path: cachedNpmConfiguration},
+	})
 }
 
-func (r InstallBuildProcess) Run(modulesDir, cacheDir, workingDir, npmrcPath string, launch bool) error {
+func (r InstallBuildProcess) Run(modulesDir, cacheDir, workingDir, npmrcPath, nodeHeadersPath, arch string, launch bool) error {
 	err := os.Mkdir(filepath.Join(modulesDir, "node_modules"), os.ModePerm)
 	if err != nil {
 		return err
@@ -45,14 +79,27 @@ func (r InstallBuildProcess) Run(modulesDir, cacheDir, workingDir, npmrcPath str
 		environment = append(environment, fmt.Sprintf("NPM_CONFIG_GLOBALCONFIG=%s", npmrcPath))
 	}
 
+	// The headers are only required once npm has installed a package that is
+	// compiled by node-gyp.
+	nodeHome, _ := r.environment.Lookup("NODE_HOME")
+	headers, headersErr := resolveNodeHeaders(nodeHome, nodeHeadersPath)
+	if headersErr == nil {
+		environment = append(environment, headers.Env()...)
+	}
+
 	if !launch {
 		environment = append(environment, "NODE_ENV=development")
 	}
 
+	retries, err := lookupNpmRetries(r.environment)
+	if err != nil {
+		return err
+	}
+
 	args := []string{"install", "--unsafe-perm", "--cache", cacheDir}
 	r.logger.Subprocess("Running 'npm %s'", strings.Join(args, " "))
 
-	err = r.executable.Execute(pexec.Execution{
+	err = executeWithRetries(r.executable, r.logger, retries, r.retryDelay, pexec.Execution{
 		Args:   args,
 		Dir:    workingDir,
 		Stdout: r.logger.ActionWriter,
@@ -63,6 +110,24 @@ func (r InstallBuildProcess) Run(modulesDir, cacheDir, workingDir, npmrcPath str
 		return fmt.Errorf("npm install failed: %w", err)
 	}
 
+	err = checkNodeHeaders(headersErr, filepath.Join(workingDir, "node_modules"))
+	if err != nil {
+		return err
+	}
+
+	// The versions that npm install resolves are not known before it has run,
+	// so the compiled output of native addons can only be stored for later
+	// builds, not restored.
+	cache, err := newNativeAddonCache(cacheDir, workingDir, nodeHome, arch)
+	if err != nil {
+		return err
+	}
+
+	err = storeNativeAddons(r.logger, cache)
+	if err != nil {
+		return err
+	}
+
 	_, err = os.Stat(filepath.Join(workingDir, "node_modules"))
 	if err != nil {
 		if errors.Is(err, os.ErrNotExist) {
@@ -6,6 +6,8 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
 	"testing"
 
 	npminstall "github.com/paketo-buildpacks/npm-install"
@@ -26,6 +28,8 @@ func testInstallBuildProcess(t *testing.T, context spec.G, it spec.S) {
 		cacheDir    string
 		workingDir  string
 		executable  *fakes.Executable
+		executions  []pexec.Execution
+		summer      *fakes.Summer
 		environment *fakes.EnvironmentConfig
 		buffer      *bytes.Buffer
 
@@ -43,8 +47,10 @@ func testInstallBuildProcess(t *testing.T, context spec.G, it spec.S) {
 		workingDir, err = os.MkdirTemp("", "working-dir")
 		Expect(err).NotTo(HaveOccurred())
 
+		executions = nil
 		executable = &fakes.Executable{}
 		executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+			executions = append(executions, execution)
 			if _, err := fmt.Fprintln(execution.Stdout, "stdout output"); err != nil {
 				return err
 			}
@@ -53,14 +59,21 @@ func testInstallBuildProcess(t *testing.T, context spec.G, it spec.S) {
 			}
 			return nil
 		}
-		environment = &fakes.EnvironmentConfig{}
+		summer = &fakes.Summer{}
 
-		environment.LookupCall.Returns.Value = "some-val"
-		environment.LookupCall.Returns.Found = true
+		environment = &fakes.EnvironmentConfig{}
+		environment.LookupCall.Stub = func(key string) (string, bool) {
+			switch key {
+			case "NPM_CONFIG_LOGLEVEL":
+				return "some-val", true
+			default:
+				return "", false
+			}
+		}
 
 		buffer = bytes.NewBuffer(nil)
 
-		process = npminstall.NewInstallBuildProcess(executable, environment, scribe.NewLogger(buffer))
+		process = npminstall.NewInstallBuildProcess(executable, summer, environment, 0, scribe.NewLogger(buffer))
 	})
 
 	it.After(func() {
@@ -70,11 +83,97 @@ func testInstallBuildProcess(t *testing.T, context spec.G, it spec.S) {
 	})
 
 	context("ShouldRun", func() {
-		it("returns true", func() {
-			run, sha, err := process.ShouldRun(workingDir, nil, "some-npmrc-path")
-			Expect(err).NotTo(HaveOccurred())
-			Expect(run).To(BeTrue())
-			Expect(sha).To(BeEmpty())
+		var summedPaths []string
+
+		it.Before(func() {
+			summedPaths = nil
+			summer.SumCall.Stub = func(paths ...string) (string, error) {
+				summedPaths = append(summedPaths, paths...)
+
+				switch {
+				case strings.Contains(paths[0], "executable_response"):
+					return "user-agent-sha", nil
+				case strings.Contains(paths[0], "npm_configuration"):
+					return "npmrc-sha", nil
+				default:
+					return fmt.Sprintf("%s-sha", filepath.Base(paths[0])), nil
+				}
+			}
+		})
+
+		context("when the layer metadata does not have a checksum", func() {
+			it("returns true and the metadata for each input", func() {
+				run, metadata, err := process.ShouldRun(workingDir, nil, "some-npmrc-path")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(run).To(BeTrue())
+
+				Expect(summedPaths).To(HaveLen(3))
+				Expect(summedPaths[0]).To(Equal(filepath.Join(workingDir, "package.json")))
+				Expect(summedPaths[1]).To(ContainSubstring("executable_response"))
+				Expect(summedPaths[2]).To(ContainSubstring("npm_configuration"))
+
+				Expect(metadata).To(HaveKeyWithValue("cache_sha", Not(BeEmpty())))
+				Expect(metadata).To(HaveKeyWithValue("inputs", map[string]interface{}{
+					"package.json":   "package.json-sha",
+					"npm-user-agent": "user-agent-sha",
+					"npmrc":          "npmrc-sha",
+				}))
+
+				for _, ex := range executions {
+					Expect(ex.Env).To(Equal(append(os.Environ(), "NPM_CONFIG_GLOBALCONFIG=some-npmrc-path")))
+				}
+				lastExecution := executions[len(executions)-1]
+				Expect(lastExecution.Args).To(Equal([]string{
+					"get",
+					"user-agent",
+				}))
+				Expect(lastExecution.Dir).To(Equal(workingDir))
+			})
+		})
+
+		context("when the checksum matches the layer metadata shasum", func() {
+			var previous map[string]interface{}
+
+			it.Before(func() {
+				var err error
+				_, previous, err = process.ShouldRun(workingDir, nil, "some-npmrc-path")
+				Expect(err).NotTo(HaveOccurred())
+			})
+
+			it("returns false", func() {
+				run, metadata, err := process.ShouldRun(workingDir, previous, "some-npmrc-path")
+				Expect(err).NotTo(HaveOccurred())
+				Expect(run).To(BeFalse())
+				Expect(metadata).To(BeNil())
+			})
+		})
+
+		context("failure cases", func() {
+			context("when the there is an error in the checksummer process", func() {
+				it.Before(func() {
+					summer.SumCall.Stub = nil
+					summer.SumCall.Returns.Error = errors.New("checksummer error")
+				})
+
+				it("returns an error", func() {
+					_, _, err := process.ShouldRun(workingDir, nil, "")
+					Expect(err).To(MatchError("checksummer error"))
+				})
+			})
+
+			context("when npm get user-agent fails to execute", func() {
+				it.Before(func() {
+					executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+						return errors.New("very bad error")
+					}
+				})
+
+				it("fails", func() {
+					_, _, err := process.ShouldRun(workingDir, nil, "")
+					Expect(err).To(MatchError(ContainSubstring("very bad error")))
+					Expect(err).To(MatchError(ContainSubstring("failed to execute npm get user-agent")))
+				})
+			})
 		})
 	})
 
@@ -85,10 +184,10 @@ func testInstallBuildProcess(t *testing.T, context spec.G, it spec.S) {
 
 		context("launch is false", func() {
 			it("succeeds", func() {
-				Expect(process.Run(modulesDir, cacheDir, workingDir, "some-npmrc-path", false)).To(Succeed())
-				Expect(executable.ExecuteCall.Receives.Execution.Args).To(Equal([]string{"install", "--unsafe-perm", "--cache", cacheDir}))
-				Expect(executable.ExecuteCall.Receives.Execution.Dir).To(Equal(workingDir))
-				Expect(executable.ExecuteCall.Receives.Execution.Env).To(Equal(append(os.Environ(), "NPM_CONFIG_LOGLEVEL=some-val", "NPM_CONFIG_GLOBALCONFIG=some-npmrc-path", "NODE_ENV=development")))
+				Expect(process.Run(modulesDir, cacheDir, workingDir, "some-npmrc-path", "", runtime.GOARCH, false)).To(Succeed())
+				Expect(executions[0].Args).To(Equal([]string{"install", "--unsafe-perm", "--cache", cacheDir}))
+				Expect(executions[0].Dir).To(Equal(workingDir))
+				Expect(executions[0].Env).To(Equal(append(os.Environ(), "NPM_CONFIG_LOGLEVEL=some-val", "NPM_CONFIG_GLOBALCONFIG=some-npmrc-path", "NODE_ENV=development")))
 				Expect(buffer.String()).To(ContainLines(
 					fmt.Sprintf("    Running 'npm install --unsafe-perm --cache %s'", cacheDir),
 					"      stdout output",
@@ -101,12 +200,80 @@ func testInstallBuildProcess(t *testing.T, context spec.G, it spec.S) {
 			})
 		})
 
+		context("when node-gyp compiles the installed packages", func() {
+			var nodeHome string
+
+			it.Before(func() {
+				nodeHome = t.TempDir()
+
+				environment.LookupCall.Stub = func(key string) (string, bool) {
+					if key == "NODE_HOME" {
+						return nodeHome, true
+					}
+					return "", false
+				}
+
+				executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+					executions = append(executions, execution)
+
+					packagePath := filepath.Join(execution.Dir, "node_modules", "some-addon")
+					Expect(os.MkdirAll(packagePath, os.ModePerm)).To(Succeed())
+					return os.WriteFile(filepath.Join(packagePath, "binding.gyp"), nil, 0600)
+				}
+			})
+
+			context("when the Node.js installation ships the headers", func() {
+				it.Before(func() {
+					Expect(os.MkdirAll(filepath.Join(nodeHome, "include", "node"), os.ModePerm)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(nodeHome, "include", "node", "common.gypi"), nil, 0600)).To(Succeed())
+				})
+
+				it("points node-gyp at NODE_HOME", func() {
+					Expect(process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)).To(Succeed())
+					Expect(executions[0].Env).To(ContainElement("NPM_CONFIG_NODEDIR=" + nodeHome))
+					Expect(executions[0].Env).NotTo(ContainElement(HavePrefix("NPM_CONFIG_TARBALL=")))
+				})
+			})
+
+			context("when a node-headers tarball is supplied", func() {
+				var tarball string
+
+				it.Before(func() {
+					tarball = filepath.Join(t.TempDir(), "node-headers.tar.gz")
+					Expect(os.WriteFile(tarball, nil, 0600)).To(Succeed())
+				})
+
+				it("points node-gyp at the tarball", func() {
+					Expect(process.Run(modulesDir, cacheDir, workingDir, "", tarball, runtime.GOARCH, true)).To(Succeed())
+					Expect(executions[0].Env).To(ContainElement("NPM_CONFIG_TARBALL=" + tarball))
+					Expect(executions[0].Env).NotTo(ContainElement(HavePrefix("NPM_CONFIG_NODEDIR=")))
+				})
+			})
+
+			context("when no headers are available", func() {
+				it("returns an error", func() {
+					err := process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)
+					Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("node-gyp headers not found in NODE_HOME (%q): native addons cannot be compiled offline", nodeHome))))
+				})
+
+				context("when no installed package is compiled by node-gyp", func() {
+					it.Before(func() {
+						executable.ExecuteCall.Stub = nil
+					})
+
+					it("succeeds", func() {
+						Expect(process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)).To(Succeed())
+					})
+				})
+			})
+		})
+
 		context("launch is true", func() {
 			it("succeeds", func() {
-				Expect(process.Run(modulesDir, cacheDir, workingDir, "some-npmrc-path", true)).To(Succeed())
-				Expect(executable.ExecuteCall.Receives.Execution.Args).To(Equal([]string{"install", "--unsafe-perm", "--cache", cacheDir}))
-				Expect(executable.ExecuteCall.Receives.Execution.Dir).To(Equal(workingDir))
-				Expect(executable.ExecuteCall.Receives.Execution.Env).To(Equal(append(os.Environ(), "NPM_CONFIG_LOGLEVEL=some-val", "NPM_CONFIG_GLOBALCONFIG=some-npmrc-path")))
+				Expect(process.Run(modulesDir, cacheDir, workingDir, "some-npmrc-path", "", runtime.GOARCH, true)).To(Succeed())
+				Expect(executions[0].Args).To(Equal([]string{"install", "--unsafe-perm", "--cache", cacheDir}))
+				Expect(executions[0].Dir).To(Equal(workingDir))
+				Expect(executions[0].Env).To(Equal(append(os.Environ(), "NPM_CONFIG_LOGLEVEL=some-val", "NPM_CONFIG_GLOBALCONFIG=some-npmrc-path")))
 				Expect(buffer.String()).To(ContainLines(
 					fmt.Sprintf("    Running 'npm install --unsafe-perm --cache %s'", cacheDir),
 					"      stdout output",
@@ -119,6 +286,35 @@ func testInstallBuildProcess(t *testing.T, context spec.G, it spec.S) {
 			})
 		})
 
+		context("when npm install fails with a transient error", func() {
+			it.Before(func() {
+				environment.LookupCall.Stub = func(key string) (string, bool) {
+					if key == "BP_NPM_RETRIES" {
+						return "1", true
+					}
+					return "", false
+				}
+
+				executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+					executions = append(executions, execution)
+					if len(executions) == 1 {
+						fmt.Fprintln(execution.Stderr, "npm ERR! code ETIMEDOUT")
+						return errors.New("exit status 1")
+					}
+					return nil
+				}
+			})
+
+			it("retries npm install", func() {
+				Expect(process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)).To(Succeed())
+				Expect(executions[0].Args[0]).To(Equal("install"))
+				Expect(executions[1].Args[0]).To(Equal("install"))
+				Expect(buffer.String()).To(ContainLines(
+					"    npm failed with transient error ETIMEDOUT, retrying in 0s (attempt 2 of 2)",
+				))
+			})
+		})
+
 		context("failure cases", func() {
 			context("when unable to write node_modules directory in layer", func() {
 				it.Before(func() {
@@ -126,7 +322,7 @@ func testInstallBuildProcess(t *testing.T, context spec.G, it spec.S) {
 				})
 
 				it("fails", func() {
-					err := process.Run(modulesDir, cacheDir, workingDir, "", true)
+					err := process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)
 					Expect(err).To(MatchError(ContainSubstring("permission denied")))
 				})
 			})
@@ -142,7 +338,7 @@ func testInstallBuildProcess(t *testing.T, context spec.G, it spec.S) {
 				})
 
 				it("returns an error", func() {
-					err := process.Run(modulesDir, cacheDir, workingDir, "", true)
+					err := process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)
 					Expect(err).To(MatchError(ContainSubstring("permission denied")))
 				})
 			})
@@ -161,7 +357,7 @@ func testInstallBuildProcess(t *testing.T, context spec.G, it spec.S) {
 				})
 
 				it("returns an error", func() {
-					err := process.Run(modulesDir, cacheDir, workingDir, "", true)
+					err := process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)
 					Expect(buffer.String()).To(ContainLines(
 						fmt.Sprintf("    Running 'npm install --unsafe-perm --cache %s'", cacheDir),
 						"      install error on stdout",
@@ -123,9 +123,9 @@ func testVendored(t *testing.T, context spec.G, it spec.S) {
 			Expect(logs).To(ContainLines(extenderBuildStr + "  Executing launch environment install process"))
 			Expect(logs).To(ContainLines(extenderBuildStr + "    Running 'npm run-script preinstall --if-present'"))
 			if extenderBuildStrEscaped != "" {
-				Expect(logs).To(ContainLines(MatchRegexp(extenderBuildStrEscaped + `    Running 'npm rebuild --nodedir='`)))
+				Expect(logs).To(ContainLines(MatchRegexp(extenderBuildStrEscaped + `    Running 'npm rebuild \S+ --nodedir='`)))
 			} else {
-				Expect(logs).To(ContainLines(MatchRegexp(`    Running 'npm rebuild --nodedir=/layers/.+/node'`)))
+				Expect(logs).To(ContainLines(MatchRegexp(`    Running 'npm rebuild \S+ --nodedir=/layers/.+/node'`)))
 			}
 			Expect(logs).To(ContainLines(extenderBuildStr + "    Running 'npm run-script postinstall --if-present'"))
 			modulePath := fmt.Sprintf("/layers/%s/launch-modules/node_modules", strings.ReplaceAll(settings.Buildpack.ID, "/", "_"))
@@ -0,0 +1,84 @@
+package npminstall
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"sync"
+)
+
+// ErrInterrupted is returned when the build is stopped by SIGTERM or SIGINT.
+var ErrInterrupted = errors.New("build interrupted")
+
+// Interrupt records the first termination signal received by the buildpack
+// so that running npm invocations can be stopped and the build can clean up
+// before it exits. The zero value is never triggered.
+type Interrupt struct {
+	state *interruptState
+}
+
+type interruptState struct {
+	once   sync.Once
+	done   chan struct{}
+	signal os.Signal
+}
+
+func NewInterrupt() Interrupt {
+	return Interrupt{
+		state: &interruptState{done: make(chan struct{})},
+	}
+}
+
+// Notify triggers the interrupt when one of the given signals is received.
+// The returned function stops listening for them.
+func (i Interrupt) Notify(signals ...os.Signal) (stop func()) {
+	received := make(chan os.Signal, 1)
+	signal.Notify(received, signals...)
+
+	stopped := make(chan struct{})
+	go func() {
+		select {
+		case sig := <-received:
+			i.Trigger(sig)
+		case <-stopped:
+		}
+	}()
+
+	return func() {
+		signal.Stop(received)
+		close(stopped)
+	}
+}
+
+// Trigger marks the build as interrupted by the given signal.
+func (i Interrupt) Trigger(sig os.Signal) {
+	if i.state == nil {
+		return
+	}
+
+	i.state.once.Do(func() {
+		i.state.signal = sig
+		close(i.state.done)
+	})
+}
+
+// Done returns a channel that is closed once the build is interrupted.
+func (i Interrupt) Done() <-chan struct{} {
+	if i.state == nil {
+		return nil
+	}
+
+	return i.state.done
+}
+
+// Err returns an error wrapping ErrInterrupted once the build is
+// interrupted, and nil before.
+func (i Interrupt) Err() error {
+	select {
+	case <-i.Done():
+		return fmt.Errorf("%w by %s", ErrInterrupted, i.state.signal)
+	default:
+		return nil
+	}
+}
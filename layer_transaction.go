@@ -0,0 +1,99 @@
+package npminstall
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/fs"
+)
+
+// layerTransaction replaces the contents of a layer only once every step of
+// the install succeeded. The previous contents are moved into a staging
+// directory next to the layer while the new contents are installed at the
+// final layer path, so that absolute paths and symlinks created by npm and
+// the linker stay valid. On failure the new contents are discarded and the
+// previous contents, which still match the layer metadata on disk, are moved
+// back.
+type layerTransaction struct {
+	path     string
+	staging  string
+	snapshot workspaceSnapshot
+}
+
+// beginLayerTransaction stages the current contents of the layer and returns
+// the layer reset for a new install.
+func beginLayerTransaction(layer packit.Layer, projectPath string) (packit.Layer, layerTransaction, error) {
+	snapshot, err := snapshotWorkspace(projectPath)
+	if err != nil {
+		return packit.Layer{}, layerTransaction{}, err
+	}
+
+	transaction := layerTransaction{
+		path:     layer.Path,
+		staging:  fmt.Sprintf("%s.previous", layer.Path),
+		snapshot: snapshot,
+	}
+
+	// A staging directory left behind by a build that was killed no longer
+	// matches any layer metadata.
+	err = os.RemoveAll(transaction.staging)
+	if err != nil {
+		return packit.Layer{}, layerTransaction{}, err
+	}
+
+	exists, err := fs.Exists(layer.Path)
+	if err != nil {
+		return packit.Layer{}, layerTransaction{}, err
+	}
+
+	if exists {
+		err = os.Rename(layer.Path, transaction.staging)
+		if err != nil {
+			return packit.Layer{}, layerTransaction{}, fmt.Errorf("failed to stage previous contents of layer: %w", err)
+		}
+	}
+
+	layer, err = layer.Reset()
+	if err != nil {
+		return packit.Layer{}, layerTransaction{}, err
+	}
+
+	return layer, transaction, nil
+}
+
+// Commit discards the previous contents of the layer.
+func (t layerTransaction) Commit() error {
+	return os.RemoveAll(t.staging)
+}
+
+// Rollback restores node_modules in the working directory and the previous
+// contents of the layer. A layer without previous contents is removed along
+// with its metadata.
+func (t layerTransaction) Rollback() error {
+	err := t.snapshot.restore(t.path)
+	if err != nil {
+		return err
+	}
+
+	err = os.RemoveAll(t.path)
+	if err != nil {
+		return err
+	}
+
+	exists, err := fs.Exists(t.staging)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return os.RemoveAll(fmt.Sprintf("%s.toml", t.path))
+	}
+
+	err = os.Rename(t.staging, t.path)
+	if err != nil {
+		return fmt.Errorf("failed to restore previous contents of layer: %w", err)
+	}
+
+	return nil
+}
@@ -10,10 +10,30 @@ import (
 )
 
 type Lockfile struct {
-	Packages map[string]struct {
-		Resolved string `json:"resolved"`
-		Link     bool   `json:"link"`
+	LockfileVersion int `json:"lockfileVersion"`
+	Packages        map[string]struct {
+		Name             string            `json:"name"`
+		Version          string            `json:"version"`
+		Resolved         string            `json:"resolved"`
+		Integrity        string            `json:"integrity"`
+		Link             bool              `json:"link"`
+		HasInstallScript bool              `json:"hasInstallScript"`
+		Dev              bool              `json:"dev"`
+		DevOptional      bool              `json:"devOptional"`
+		Dependencies     map[string]string `json:"dependencies"`
 	} `json:"packages"`
+
+	// Dependencies holds the nested dependency tree of lockfileVersion 1,
+	// which has no "packages" section.
+	Dependencies map[string]LockfileDependency `json:"dependencies"`
+}
+
+type LockfileDependency struct {
+	Version      string                        `json:"version"`
+	Integrity    string                        `json:"integrity"`
+	Dev          bool                          `json:"dev"`
+	Requires     map[string]string             `json:"requires"`
+	Dependencies map[string]LockfileDependency `json:"dependencies"`
 }
 
 type LinkedModuleResolver struct {
@@ -26,7 +46,11 @@ func NewLinkedModuleResolver(linker Symlinker) LinkedModuleResolver {
 	}
 }
 
-func (r LinkedModuleResolver) ParseLockfile(lockfilePath string) (lockfile Lockfile, err error) {
+func (r LinkedModuleResolver) ParseLockfile(lockfilePath string) (Lockfile, error) {
+	return parseLockfile(lockfilePath)
+}
+
+func parseLockfile(lockfilePath string) (lockfile Lockfile, err error) {
 	file, err := os.Open(lockfilePath)
 	if err != nil {
 		return Lockfile{}, fmt.Errorf(`failed to open "package-lock.json": %w`, err)
@@ -66,7 +90,7 @@ func (r LinkedModuleResolver) Copy(lockfilePath, sourceLayerPath, targetLayerPat
 				return fmt.Errorf("failed to setup linked module directory scaffolding: %w", err)
 			}
 
-			err = fs.Copy(source, destination)
+			err = NewTreeCopier().Copy(source, destination)
 			if err != nil {
 				return fmt.Errorf("failed to copy linked module directory to layer path: %w", err)
 			}
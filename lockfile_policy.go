@@ -0,0 +1,40 @@
+package npminstall
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// checkLockfileVersion enforces BP_NPM_LOCKFILE_MIN_VERSION against the
+// package-lock.json committed with the application.
+func checkLockfileVersion(environment EnvironmentConfig, lockfilePath string) error {
+	value, ok := environment.Lookup("BP_NPM_LOCKFILE_MIN_VERSION")
+	if !ok {
+		return nil
+	}
+
+	minimum, err := strconv.Atoi(value)
+	if err != nil {
+		return fmt.Errorf("failed to parse BP_NPM_LOCKFILE_MIN_VERSION: %w", err)
+	}
+
+	lockfile, err := parseLockfile(lockfilePath)
+	if err != nil {
+		return err
+	}
+
+	if lockfile.LockfileVersion < minimum {
+		return fmt.Errorf("lockfile policy violation: package-lock.json has lockfileVersion %d but BP_NPM_LOCKFILE_MIN_VERSION requires at least %d: regenerate it with 'npm install --lockfile-version %d' and commit the result", lockfile.LockfileVersion, minimum, minimum)
+	}
+
+	return nil
+}
+
+// lockfileOutOfSync reports whether npm failed because package.json and
+// package-lock.json are out of sync, which is how 'npm ci' refuses to install
+// from a lockfile it would have to modify.
+func lockfileOutOfSync(err error) bool {
+	var failure npmFailure
+	return errors.As(err, &failure) && failure.code == "EUSAGE"
+}
@@ -0,0 +1,530 @@
+package npminstall
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/paketo-buildpacks/packit/v2/chronos"
+	"github.com/paketo-buildpacks/packit/v2/fs"
+	"github.com/paketo-buildpacks/packit/v2/pexec"
+	"github.com/paketo-buildpacks/packit/v2/scribe"
+)
+
+// NativeAddonCacheDir is the directory inside of the npm-cache layer that
+// holds the compiled output of native addons from previous builds.
+const NativeAddonCacheDir = "native-addons"
+
+// nativeAddonCache stores the build/Release output of native addons keyed by
+// package name, version, integrity, Node ABI and architecture. Only targets
+// whose compiled output is all that rebuilding them produces are cached. A
+// cache with an empty path is disabled and never reports a hit.
+type nativeAddonCache struct {
+	path       string
+	workingDir string
+	abi        string
+	arch       string
+	integrity  map[string]string
+	used       map[string]bool
+}
+
+func newNativeAddonCache(cacheDir, workingDir, nodeHome, arch string) (nativeAddonCache, error) {
+	abi, err := nodeABI(nodeHome)
+	if err != nil {
+		return nativeAddonCache{}, err
+	}
+
+	if abi == "" {
+		return nativeAddonCache{}, nil
+	}
+
+	// Before 'npm ci' has installed node_modules, the integrity of the
+	// packages it is going to install is read from package-lock.json.
+	integrity := map[string]string{}
+	for _, lockfilePath := range []string{
+		filepath.Join(workingDir, "node_modules", ".package-lock.json"),
+		filepath.Join(workingDir, "package-lock.json"),
+	} {
+		exists, err := fs.Exists(lockfilePath)
+		if err != nil {
+			return nativeAddonCache{}, err
+		}
+
+		if !exists {
+			continue
+		}
+
+		lockfile, err := parseLockfile(lockfilePath)
+		if err != nil {
+			return nativeAddonCache{}, err
+		}
+
+		for path, pkg := range lockfile.Packages {
+			integrity[path] = pkg.Integrity
+		}
+
+		break
+	}
+
+	return nativeAddonCache{
+		path:       filepath.Join(cacheDir, NativeAddonCacheDir),
+		workingDir: workingDir,
+		abi:        abi,
+		arch:       arch,
+		integrity:  integrity,
+		used:       map[string]bool{},
+	}, nil
+}
+
+// nodeABI reads NODE_MODULE_VERSION from the headers shipped with the Node.js
+// installation. An empty ABI is returned when the headers are not available.
+func nodeABI(nodeHome string) (abi string, err error) {
+	if nodeHome == "" {
+		return "", nil
+	}
+
+	file, err := os.Open(filepath.Join(nodeHome, "include", "node", "node_version.h"))
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf("failed to read node_version.h: %w", err)
+	}
+	defer func() {
+		if closeErr := file.Close(); closeErr != nil && err == nil {
+			err = fmt.Errorf("failed to close node_version.h: %w", closeErr)
+		}
+	}()
+
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 3 && fields[0] == "#define" && fields[1] == "NODE_MODULE_VERSION" {
+			return fields[2], nil
+		}
+	}
+
+	return "", scanner.Err()
+}
+
+func (c nativeAddonCache) enabled() bool {
+	return c.path != ""
+}
+
+// key returns the cache key and a "name@version" description of the package
+// installed at the given path.
+func (c nativeAddonCache) key(packagePath string) (string, string, error) {
+	var pkg struct {
+		Name    string `json:"name"`
+		Version string `json:"version"`
+	}
+
+	content, err := os.ReadFile(filepath.Join(packagePath, "package.json"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", "", fmt.Errorf("failed to read package.json: %w", err)
+	}
+
+	if err == nil {
+		err = json.Unmarshal(content, &pkg)
+		if err != nil {
+			return "", "", fmt.Errorf("failed to parse %s: %w", filepath.Join(packagePath, "package.json"), err)
+		}
+	}
+
+	rel, err := filepath.Rel(c.workingDir, packagePath)
+	if err != nil {
+		return "", "", err
+	}
+
+	var description string
+	if pkg.Name != "" {
+		description = fmt.Sprintf("%s@%s", pkg.Name, pkg.Version)
+	}
+
+	return c.entryKey(pkg.Name, pkg.Version, c.integrity[filepath.ToSlash(rel)]), description, nil
+}
+
+func (c nativeAddonCache) entryKey(name, version, integrity string) string {
+	hash := sha256.New()
+	for _, field := range []string{name, version, integrity, c.abi, c.arch} {
+		fmt.Fprintf(hash, "%s\n", field)
+	}
+
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// installLifecycleScripts are the scripts of the app itself that 'npm ci'
+// runs after installing its dependencies.
+var installLifecycleScripts = []string{"preinstall", "install", "postinstall", "prepublish", "preprepare", "prepare", "postprepare"}
+
+// Restorable returns the cache entries for the packages that 'npm ci' is going
+// to install into the working directory, keyed by their path in
+// package-lock.json, if the compiled output of every package with install
+// scripts is in the cache and the app itself defines no install scripts.
+// Only then can 'npm ci' skip all install scripts and have the compiled
+// output restored from the cache instead. Otherwise nil is returned.
+func (c nativeAddonCache) Restorable() (map[string]string, error) {
+	if !c.enabled() {
+		return nil, nil
+	}
+
+	var pkg struct {
+		Scripts map[string]string `json:"scripts"`
+	}
+
+	content, err := os.ReadFile(filepath.Join(c.workingDir, "package.json"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("failed to read package.json: %w", err)
+	}
+
+	if err == nil {
+		err = json.Unmarshal(content, &pkg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse %s: %w", filepath.Join(c.workingDir, "package.json"), err)
+		}
+	}
+
+	for _, script := range installLifecycleScripts {
+		if _, ok := pkg.Scripts[script]; ok {
+			return nil, nil
+		}
+	}
+
+	lockfilePath := filepath.Join(c.workingDir, "package-lock.json")
+	exists, err := fs.Exists(lockfilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if !exists {
+		return nil, nil
+	}
+
+	lockfile, err := parseLockfile(lockfilePath)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := map[string]string{}
+	for path, pkg := range lockfile.Packages {
+		if !pkg.HasInstallScript {
+			continue
+		}
+
+		index := strings.LastIndex(path, "node_modules/")
+		if index < 0 || pkg.Link {
+			return nil, nil
+		}
+
+		name := pkg.Name
+		if name == "" {
+			name = path[index+len("node_modules/"):]
+		}
+
+		key := c.entryKey(name, pkg.Version, pkg.Integrity)
+		exists, err := fs.Exists(filepath.Join(c.path, key, "Release"))
+		if err != nil {
+			return nil, err
+		}
+
+		if !exists {
+			return nil, nil
+		}
+
+		entries[path] = key
+	}
+
+	if len(entries) == 0 {
+		return nil, nil
+	}
+
+	return entries, nil
+}
+
+// RestoreInstalled copies the given entries returned by Restorable into the
+// packages that 'npm ci' installed without running their install scripts.
+func (c nativeAddonCache) RestoreInstalled(logger scribe.Logger, entries map[string]string) error {
+	var paths []string
+	for path := range entries {
+		paths = append(paths, path)
+	}
+
+	sort.Strings(paths)
+
+	for _, path := range paths {
+		packagePath := filepath.Join(c.workingDir, filepath.FromSlash(path))
+		destination := filepath.Join(packagePath, "build", "Release")
+		err := os.RemoveAll(destination)
+		if err != nil {
+			return fmt.Errorf("failed to restore %s from the native addon cache: %w", path, err)
+		}
+
+		err = os.MkdirAll(filepath.Dir(destination), os.ModePerm)
+		if err != nil {
+			return fmt.Errorf("failed to restore %s from the native addon cache: %w", path, err)
+		}
+
+		err = fs.Copy(filepath.Join(c.path, entries[path], "Release"), destination)
+		if err != nil {
+			return fmt.Errorf("failed to restore %s from the native addon cache: %w", path, err)
+		}
+
+		c.used[entries[path]] = true
+
+		_, description, err := c.key(packagePath)
+		if err != nil || description == "" {
+			description = path
+		}
+		logger.Subprocess("Native addon cache hit: %s", description)
+	}
+
+	return nil
+}
+
+// Restore copies the cached build/Release output into every installation of
+// the target. It reports a hit only if all of them were found in the cache.
+func (c nativeAddonCache) Restore(target rebuildTarget) (bool, error) {
+	if !c.enabled() || target.Force || !target.cacheable() {
+		return false, nil
+	}
+
+	var keys, entries []string
+	for _, packagePath := range target.Paths {
+		key, _, err := c.key(packagePath)
+		if err != nil {
+			return false, err
+		}
+
+		exists, err := fs.Exists(filepath.Join(c.path, key, "Release"))
+		if err != nil {
+			return false, err
+		}
+
+		if !exists {
+			return false, nil
+		}
+
+		keys = append(keys, key)
+		entries = append(entries, filepath.Join(c.path, key, "Release"))
+	}
+
+	for i, packagePath := range target.Paths {
+		destination := filepath.Join(packagePath, "build", "Release")
+		err := os.RemoveAll(destination)
+		if err != nil {
+			return false, err
+		}
+
+		err = os.MkdirAll(filepath.Dir(destination), os.ModePerm)
+		if err != nil {
+			return false, err
+		}
+
+		err = fs.Copy(entries[i], destination)
+		if err != nil {
+			return false, err
+		}
+
+		c.used[keys[i]] = true
+	}
+
+	return true, nil
+}
+
+// Store copies the build/Release output of every installation of the target
+// into the cache. Installations without compiled output are skipped.
+func (c nativeAddonCache) Store(target rebuildTarget) error {
+	if !c.enabled() || !target.cacheable() {
+		return nil
+	}
+
+	for _, packagePath := range target.Paths {
+		source := filepath.Join(packagePath, "build", "Release")
+		exists, err := fs.Exists(source)
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			continue
+		}
+
+		key, _, err := c.key(packagePath)
+		if err != nil {
+			return err
+		}
+
+		destination := filepath.Join(c.path, key, "Release")
+		err = os.RemoveAll(destination)
+		if err != nil {
+			return err
+		}
+
+		err = os.MkdirAll(filepath.Dir(destination), os.ModePerm)
+		if err != nil {
+			return err
+		}
+
+		err = fs.Copy(source, destination)
+		if err != nil {
+			return err
+		}
+
+		c.used[key] = true
+	}
+
+	return nil
+}
+
+// Prune removes the entries that were neither restored nor stored by this
+// build, such as entries for package versions that are no longer installed
+// or for a previous Node ABI.
+func (c nativeAddonCache) Prune() error {
+	if !c.enabled() {
+		return nil
+	}
+
+	entries, err := os.ReadDir(c.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to prune the native addon cache: %w", err)
+	}
+
+	for _, entry := range entries {
+		if c.used[entry.Name()] {
+			continue
+		}
+
+		err = os.RemoveAll(filepath.Join(c.path, entry.Name()))
+		if err != nil {
+			return fmt.Errorf("failed to prune the native addon cache: %w", err)
+		}
+	}
+
+	return nil
+}
+
+// restored reports whether the output of every installation of the target
+// was restored from the cache by this build.
+func (c nativeAddonCache) restored(target rebuildTarget) bool {
+	for _, packagePath := range target.Paths {
+		key, _, err := c.key(packagePath)
+		if err != nil || !c.used[key] {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (c nativeAddonCache) describe(target rebuildTarget) string {
+	var descriptions []string
+	for _, packagePath := range target.Paths {
+		_, description, err := c.key(packagePath)
+		if err != nil || description == "" {
+			description = target.Name
+		}
+		descriptions = append(descriptions, description)
+	}
+
+	return strings.Join(uniqueStrings(descriptions), ", ")
+}
+
+// buildNativeAddons rebuilds each target from source with 'npm rebuild',
+// unless its compiled output can be restored from the native addon cache.
+// Entries that were not used are then removed from the cache.
+func buildNativeAddons(executable Executable, logger scribe.Logger, clock chronos.Clock, cache nativeAddonCache, headers nodeHeaders, targets []rebuildTarget, workingDir string, env []string) error {
+	env = append(env, headers.Env()...)
+	for _, target := range targets {
+		if cache.enabled() && !target.Force && target.cacheable() {
+			hit, err := cache.Restore(target)
+			if err != nil {
+				return fmt.Errorf("failed to restore %s from the native addon cache: %w", target.Name, err)
+			}
+
+			if hit {
+				logger.Subprocess("Native addon cache hit: %s", cache.describe(target))
+				continue
+			}
+
+			logger.Subprocess("Native addon cache miss: %s", cache.describe(target))
+		}
+
+		args := append([]string{"rebuild", target.Name}, headers.RebuildArgs()...)
+		logger.Subprocess("Running 'npm %s'", strings.Join(args, " "))
+		duration, err := clock.Measure(func() error {
+			return executeWithRetries(executable, logger, 0, 0, pexec.Execution{
+				Args:   args,
+				Dir:    workingDir,
+				Stdout: logger.ActionWriter,
+				Stderr: logger.ActionWriter,
+				Env:    env,
+			})
+		})
+		if err != nil {
+			return fmt.Errorf("npm rebuild failed: %s", err)
+		}
+		logger.Action("Rebuilt %s in %s", target.Name, duration.Round(time.Millisecond))
+
+		err = cache.Store(target)
+		if err != nil {
+			return fmt.Errorf("failed to store %s in the native addon cache: %w", target.Name, err)
+		}
+	}
+
+	return cache.Prune()
+}
+
+func uniqueStrings(values []string) []string {
+	seen := map[string]bool{}
+
+	var unique []string
+	for _, value := range values {
+		if !seen[value] {
+			seen[value] = true
+			unique = append(unique, value)
+		}
+	}
+
+	return unique
+}
+
+// storeNativeAddons stores the compiled output of the native addons that
+// 'npm ci' or 'npm install' built into the working directory of the given
+// cache, so that later builds of the same packages can restore it. Entries
+// restored by this build are kept as they are.
+func storeNativeAddons(logger scribe.Logger, cache nativeAddonCache) error {
+	if !cache.enabled() {
+		return nil
+	}
+
+	targets, err := findRebuildTargets(filepath.Join(cache.workingDir, "node_modules"))
+	if err != nil {
+		return err
+	}
+
+	for _, target := range targets {
+		if !target.cacheable() || cache.restored(target) {
+			continue
+		}
+
+		err = cache.Store(target)
+		if err != nil {
+			return fmt.Errorf("failed to store %s in the native addon cache: %w", target.Name, err)
+		}
+
+		logger.Subprocess("Native addon cache store: %s", cache.describe(target))
+	}
+
+	return cache.Prune()
+}
@@ -0,0 +1,114 @@
+package npminstall
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/fs"
+)
+
+// linkNodeBuildCache points node_modules/.cache, where tools such as babel,
+// webpack, eslint and terser keep their caches, at the given cached layer.
+// Buildpacks that run after this one write their caches straight into the
+// layer, which the lifecycle restores on the next build. A .cache directory
+// left in node_modules by a previous build seeds an empty layer.
+func linkNodeBuildCache(nodeModulesPath string, layer packit.Layer) error {
+	exists, err := fs.Exists(nodeModulesPath)
+	if err != nil {
+		return err
+	}
+
+	if !exists {
+		return nil
+	}
+
+	err = os.MkdirAll(layer.Path, os.ModePerm)
+	if err != nil {
+		return err
+	}
+
+	linkName := filepath.Join(nodeModulesPath, ".cache")
+	info, err := os.Lstat(linkName)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err == nil && info.IsDir() && fs.IsEmptyDir(layer.Path) {
+		err = os.Remove(layer.Path)
+		if err != nil {
+			return err
+		}
+
+		err = fs.Move(linkName, layer.Path)
+		if err != nil {
+			return fmt.Errorf("failed to move node_modules/.cache into layer: %w", err)
+		}
+	}
+
+	err = os.RemoveAll(linkName)
+	if err != nil {
+		return err
+	}
+
+	return os.Symlink(layer.Path, linkName)
+}
+
+// unlinkNodeBuildCache removes node_modules/.cache if it points at the build
+// cache layer, which is not available at launch. Such a link is carried over
+// when the launch node_modules are derived from the build node_modules.
+func unlinkNodeBuildCache(nodeModulesPath, layerPath string) error {
+	linkName := filepath.Join(nodeModulesPath, ".cache")
+	link, err := os.Readlink(linkName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) || errors.Is(err, os.ErrInvalid) {
+			return nil
+		}
+		return err
+	}
+
+	if link != layerPath {
+		return nil
+	}
+
+	return os.Remove(linkName)
+}
+
+// shareNodeBuildCache links node_modules/.cache of a layer that is also used
+// at launch to the given cached layer through tmpPath. The cached layer is not
+// available at launch, where the setup-symlinks exec.d binary creates an
+// empty directory at tmpPath instead, so the link never dangles.
+func shareNodeBuildCache(nodeModulesPath string, layer packit.Layer, tmpPath string) error {
+	err := linkNodeBuildCache(nodeModulesPath, layer)
+	if err != nil {
+		return err
+	}
+
+	linkName := filepath.Join(nodeModulesPath, ".cache")
+	_, err = os.Lstat(linkName)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return err
+	}
+
+	err = os.RemoveAll(tmpPath)
+	if err != nil {
+		return err
+	}
+
+	err = os.Symlink(layer.Path, tmpPath)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(linkName)
+	if err != nil {
+		return err
+	}
+
+	return os.Symlink(tmpPath, linkName)
+}
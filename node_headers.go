@@ -0,0 +1,84 @@
+package npminstall
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/paketo-buildpacks/packit/v2/fs"
+)
+
+// nodeHeaders describes where node-gyp finds the Node.js headers used to
+// compile native addons, either the headers shipped with the Node.js
+// installation or a headers tarball supplied through a service binding.
+type nodeHeaders struct {
+	nodeDir string
+	tarball string
+}
+
+// resolveNodeHeaders prefers the given headers tarball and falls back to the
+// headers in NODE_HOME. It returns an error when neither is available so that
+// builds fail before node-gyp tries to download the headers from nodejs.org.
+func resolveNodeHeaders(nodeHome, tarball string) (nodeHeaders, error) {
+	if tarball != "" {
+		exists, err := fs.Exists(tarball)
+		if err != nil {
+			return nodeHeaders{}, err
+		}
+
+		if !exists {
+			return nodeHeaders{}, fmt.Errorf("node-gyp headers tarball %s does not exist: check the service binding of type 'node-headers'", tarball)
+		}
+
+		return nodeHeaders{tarball: tarball}, nil
+	}
+
+	exists, err := fs.Exists(filepath.Join(nodeHome, "include", "node", "common.gypi"))
+	if err != nil {
+		return nodeHeaders{}, err
+	}
+
+	if nodeHome == "" || !exists {
+		return nodeHeaders{}, fmt.Errorf("node-gyp headers not found in NODE_HOME (%q): native addons cannot be compiled offline: use a Node.js installation that ships include/node or supply a headers tarball through a service binding of type 'node-headers'", nodeHome)
+	}
+
+	return nodeHeaders{nodeDir: nodeHome}, nil
+}
+
+// checkNodeHeaders returns the given error from resolving the headers if the
+// packages installed into the given node_modules directory are compiled by
+// node-gyp, as 'npm ci' and 'npm install' only learn that once they have
+// installed them.
+func checkNodeHeaders(headersErr error, nodeModulesPath string) error {
+	if headersErr == nil {
+		return nil
+	}
+
+	targets, err := findRebuildTargets(nodeModulesPath)
+	if err != nil {
+		return err
+	}
+
+	if compilesNativeCode(targets) {
+		return headersErr
+	}
+
+	return nil
+}
+
+// Env returns the npm configuration that points node-gyp at the headers.
+func (h nodeHeaders) Env() []string {
+	if h.tarball != "" {
+		return []string{fmt.Sprintf("NPM_CONFIG_TARBALL=%s", h.tarball)}
+	}
+
+	return []string{fmt.Sprintf("NPM_CONFIG_NODEDIR=%s", h.nodeDir)}
+}
+
+// RebuildArgs returns the arguments passed to 'npm rebuild'.
+func (h nodeHeaders) RebuildArgs() []string {
+	if h.tarball != "" {
+		return nil
+	}
+
+	return []string{fmt.Sprintf("--nodedir=%s", h.nodeDir)}
+}
@@ -0,0 +1,431 @@
+package npminstall
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/scribe"
+)
+
+// LookupNpmCacheMaxSize returns the size in bytes configured through
+// BP_NPM_CACHE_MAX_SIZE, e.g. "512M" or "2G". Zero means the size of the npm
+// cache is not capped.
+func LookupNpmCacheMaxSize(environment EnvironmentConfig) (int64, error) {
+	value, ok := environment.Lookup("BP_NPM_CACHE_MAX_SIZE")
+	if !ok {
+		return 0, nil
+	}
+
+	size, err := parseByteSize(value)
+	if err != nil {
+		return 0, fmt.Errorf("invalid value for BP_NPM_CACHE_MAX_SIZE %q: must be a size such as \"512M\" or \"2G\"", value)
+	}
+
+	return size, nil
+}
+
+// npmCacheContent is a single file in the content store of the npm cache,
+// keyed by its path relative to the store, which is derived from its
+// integrity.
+type npmCacheContent struct {
+	key     string
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// CollectNpmCacheGarbage removes the entries of the npm cache layer that are
+// not referenced by an integrity value of the project's package-lock.json.
+// When maxSize is positive, the least recently written of the remaining
+// entries are then removed until the cache fits. The size of the cache is
+// recorded in the layer metadata, and the fingerprint of a merged vendored
+// npm-cache is dropped when entries are evicted. Only the _cacache directory that holds the
+// entries is measured; other contents of the layer, such as the native addon
+// cache, are managed separately.
+func CollectNpmCacheGarbage(logger scribe.Emitter, projectPath string, cacheLayer packit.Layer, maxSize int64) (packit.Layer, error) {
+	logger.Process("Collecting garbage in npm cache")
+
+	cacheDir := filepath.Join(cacheLayer.Path, "_cacache")
+	sizeBefore, err := directorySize(cacheDir)
+	if err != nil {
+		return packit.Layer{}, fmt.Errorf("failed to measure npm cache: %w", err)
+	}
+
+	referenced, err := lockfileIntegrityKeys(filepath.Join(projectPath, "package-lock.json"))
+	if err != nil {
+		return packit.Layer{}, err
+	}
+
+	contents, err := listNpmCacheContent(filepath.Join(cacheDir, "content-v2"))
+	if err != nil {
+		return packit.Layer{}, fmt.Errorf("failed to list npm cache contents: %w", err)
+	}
+
+	if referenced == nil {
+		logger.Subprocess("No package-lock.json found, keeping all entries")
+	}
+
+	var kept []npmCacheContent
+	var unreferenced int
+	for _, content := range contents {
+		if referenced != nil && !referenced[content.key] {
+			err = os.Remove(content.path)
+			if err != nil {
+				return packit.Layer{}, fmt.Errorf("failed to remove npm cache entry: %w", err)
+			}
+			unreferenced++
+			continue
+		}
+
+		kept = append(kept, content)
+	}
+
+	var evicted int
+	if maxSize > 0 {
+		size, err := directorySize(cacheDir)
+		if err != nil {
+			return packit.Layer{}, fmt.Errorf("failed to measure npm cache: %w", err)
+		}
+
+		sort.SliceStable(kept, func(i, j int) bool {
+			return kept[i].modTime.Before(kept[j].modTime)
+		})
+
+		for len(kept) > 0 && size > maxSize {
+			err = os.Remove(kept[0].path)
+			if err != nil {
+				return packit.Layer{}, fmt.Errorf("failed to remove npm cache entry: %w", err)
+			}
+
+			size -= kept[0].size
+			kept = kept[1:]
+			evicted++
+		}
+	}
+
+	keys := make(map[string]bool, len(kept))
+	for _, content := range kept {
+		keys[content.key] = true
+	}
+
+	err = pruneNpmCacheIndex(filepath.Join(cacheDir, "index-v5"), keys)
+	if err != nil {
+		return packit.Layer{}, fmt.Errorf("failed to prune npm cache index: %w", err)
+	}
+
+	sizeAfter, err := directorySize(cacheDir)
+	if err != nil {
+		return packit.Layer{}, fmt.Errorf("failed to measure npm cache: %w", err)
+	}
+
+	logger.Subprocess("Removed %d entries not referenced by package-lock.json", unreferenced)
+	if maxSize > 0 {
+		logger.Subprocess("Removed %d entries to stay below BP_NPM_CACHE_MAX_SIZE (%s)", evicted, formatByteSize(maxSize))
+	}
+	logger.Subprocess("Reclaimed %s, cache size is now %s", formatByteSize(sizeBefore-sizeAfter), formatByteSize(sizeAfter))
+	logger.Break()
+
+	if cacheLayer.Metadata == nil {
+		cacheLayer.Metadata = map[string]interface{}{}
+	}
+	cacheLayer.Metadata["cache_size"] = sizeAfter
+
+	// Entries evicted to stay below the size limit may be referenced by the
+	// lockfile and have come from a vendored npm-cache, which has to be merged
+	// again on the next build.
+	if evicted > 0 {
+		delete(cacheLayer.Metadata, "cache_sha")
+	}
+
+	return cacheLayer, nil
+}
+
+type npmCacheLockfileDependency struct {
+	Integrity    string                                `json:"integrity"`
+	Dependencies map[string]npmCacheLockfileDependency `json:"dependencies"`
+}
+
+// lockfileIntegrityKeys returns the content store keys of every integrity
+// value in the lockfile, covering both the "packages" of lockfileVersion 2
+// and 3 and the nested "dependencies" of lockfileVersion 1. It returns nil if
+// there is no lockfile.
+func lockfileIntegrityKeys(lockfilePath string) (map[string]bool, error) {
+	content, err := os.ReadFile(lockfilePath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf(`failed to read "package-lock.json": %w`, err)
+	}
+
+	var lockfile struct {
+		Packages map[string]struct {
+			Integrity string `json:"integrity"`
+		} `json:"packages"`
+		Dependencies map[string]npmCacheLockfileDependency `json:"dependencies"`
+	}
+
+	err = json.Unmarshal(content, &lockfile)
+	if err != nil {
+		return nil, fmt.Errorf(`failed to parse "package-lock.json": %w`, err)
+	}
+
+	keys := map[string]bool{}
+	for _, pkg := range lockfile.Packages {
+		for _, key := range integrityKeys(pkg.Integrity) {
+			keys[key] = true
+		}
+	}
+
+	var walk func(map[string]npmCacheLockfileDependency)
+	walk = func(dependencies map[string]npmCacheLockfileDependency) {
+		for _, dependency := range dependencies {
+			for _, key := range integrityKeys(dependency.Integrity) {
+				keys[key] = true
+			}
+			walk(dependency.Dependencies)
+		}
+	}
+	walk(lockfile.Dependencies)
+
+	return keys, nil
+}
+
+// integrityKeys converts a subresource integrity value such as
+// "sha512-<base64>" into the paths under which npm stores the content in its
+// cache, e.g. "sha512/ab/cd/ef...". Values holding several hashes yield a key
+// for each of them.
+func integrityKeys(integrity string) []string {
+	var keys []string
+	for _, token := range strings.Fields(integrity) {
+		token, _, _ = strings.Cut(token, "?")
+
+		algorithm, digest, found := strings.Cut(token, "-")
+		if !found {
+			continue
+		}
+
+		sum, err := base64.StdEncoding.DecodeString(digest)
+		if err != nil {
+			continue
+		}
+
+		hexSum := hex.EncodeToString(sum)
+		if len(hexSum) < 5 {
+			continue
+		}
+
+		keys = append(keys, filepath.Join(algorithm, hexSum[:2], hexSum[2:4], hexSum[4:]))
+	}
+
+	return keys
+}
+
+func listNpmCacheContent(contentDir string) ([]npmCacheContent, error) {
+	var contents []npmCacheContent
+	err := filepath.WalkDir(contentDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == contentDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		key, err := filepath.Rel(contentDir, path)
+		if err != nil {
+			return err
+		}
+
+		contents = append(contents, npmCacheContent{
+			key:     key,
+			path:    path,
+			size:    info.Size(),
+			modTime: info.ModTime(),
+		})
+
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return contents, nil
+}
+
+// pruneNpmCacheIndex removes the index entries whose content is not in keys.
+// Each index bucket holds one entry per line in the form "<hash>\t<json>";
+// kept lines are written back unchanged so that their hashes stay valid.
+func pruneNpmCacheIndex(indexDir string, keys map[string]bool) error {
+	return filepath.WalkDir(indexDir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == indexDir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return err
+		}
+
+		var kept bytes.Buffer
+		var removed bool
+		scanner := bufio.NewScanner(bytes.NewReader(content))
+		scanner.Buffer(nil, len(content)+1)
+		for scanner.Scan() {
+			line := scanner.Text()
+			if line == "" {
+				continue
+			}
+
+			if !indexEntryReferences(line, keys) {
+				removed = true
+				continue
+			}
+
+			kept.WriteString("\n")
+			kept.WriteString(line)
+		}
+		if err := scanner.Err(); err != nil {
+			return err
+		}
+
+		if !removed {
+			return nil
+		}
+
+		if kept.Len() == 0 {
+			return os.Remove(path)
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		return os.WriteFile(path, kept.Bytes(), info.Mode().Perm())
+	})
+}
+
+func indexEntryReferences(line string, keys map[string]bool) bool {
+	_, body, found := strings.Cut(line, "\t")
+	if !found {
+		return false
+	}
+
+	var entry struct {
+		Integrity *string `json:"integrity"`
+	}
+
+	err := json.Unmarshal([]byte(body), &entry)
+	if err != nil || entry.Integrity == nil {
+		return false
+	}
+
+	for _, key := range integrityKeys(*entry.Integrity) {
+		if keys[key] {
+			return true
+		}
+	}
+
+	return false
+}
+
+func directorySize(dir string) (int64, error) {
+	var size int64
+	err := filepath.WalkDir(dir, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			if errors.Is(err, fs.ErrNotExist) && path == dir {
+				return filepath.SkipDir
+			}
+			return err
+		}
+
+		if !entry.Type().IsRegular() {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		size += info.Size()
+		return nil
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	return size, nil
+}
+
+// parseByteSize parses a number of bytes with an optional binary unit suffix,
+// e.g. "1048576", "512K", "512M", "2G" or "2GiB".
+func parseByteSize(value string) (int64, error) {
+	value = strings.TrimSpace(value)
+	upper := strings.ToUpper(value)
+	upper = strings.TrimSuffix(upper, "IB")
+	upper = strings.TrimSuffix(upper, "B")
+
+	multiplier := int64(1)
+	if upper != "" {
+		if shift := strings.IndexByte("KMGT", upper[len(upper)-1]); shift >= 0 {
+			multiplier = int64(1) << (10 * (shift + 1))
+			upper = upper[:len(upper)-1]
+		}
+	}
+
+	size, err := strconv.ParseInt(strings.TrimSpace(upper), 10, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if size < 0 || size > (1<<62)/multiplier {
+		return 0, fmt.Errorf("size %q is out of range", value)
+	}
+
+	return size * multiplier, nil
+}
+
+func formatByteSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+
+	divisor, exponent := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		divisor *= unit
+		exponent++
+	}
+
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(divisor), "KMGTPE"[exponent])
+}
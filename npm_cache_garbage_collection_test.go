@@ -0,0 +1,265 @@
+package npminstall_test
+
+import (
+	"bytes"
+	"crypto/sha1"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	npminstall "github.com/paketo-buildpacks/npm-install"
+	"github.com/paketo-buildpacks/npm-install/fakes"
+	"github.com/paketo-buildpacks/packit/v2"
+	"github.com/paketo-buildpacks/packit/v2/scribe"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testCollectNpmCacheGarbage(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+		buffer *bytes.Buffer
+		logger scribe.Emitter
+
+		workingDir string
+		cacheLayer packit.Layer
+
+		leftpadIntegrity string
+		leftpadContent   string
+		rightpadContent  string
+		oldContent       string
+	)
+
+	// writeCacheEntry stores content in the npm cache the way cacache does and
+	// returns its integrity and the path of its content file.
+	writeCacheEntry := func(key, content string, modTime time.Time) (string, string) {
+		sum := sha512.Sum512([]byte(content))
+		integrity := "sha512-" + base64.StdEncoding.EncodeToString(sum[:])
+		hexSum := hex.EncodeToString(sum[:])
+
+		contentPath := filepath.Join(cacheLayer.Path, "_cacache", "content-v2", "sha512", hexSum[:2], hexSum[2:4], hexSum[4:])
+		Expect(os.MkdirAll(filepath.Dir(contentPath), os.ModePerm)).To(Succeed())
+		Expect(os.WriteFile(contentPath, []byte(content), 0644)).To(Succeed())
+		Expect(os.Chtimes(contentPath, modTime, modTime)).To(Succeed())
+
+		keySum := sha1.Sum([]byte(key))
+		keyHex := hex.EncodeToString(keySum[:])
+		body := fmt.Sprintf(`{"key":%q,"integrity":%q,"time":%d,"size":%d}`, key, integrity, modTime.UnixMilli(), len(content))
+		bodySum := sha1.Sum([]byte(body))
+
+		bucketPath := filepath.Join(cacheLayer.Path, "_cacache", "index-v5", keyHex[:2], keyHex[2:4], keyHex[4:])
+		Expect(os.MkdirAll(filepath.Dir(bucketPath), os.ModePerm)).To(Succeed())
+		Expect(os.WriteFile(bucketPath, []byte(fmt.Sprintf("\n%s\t%s", hex.EncodeToString(bodySum[:]), body)), 0644)).To(Succeed())
+
+		return integrity, contentPath
+	}
+
+	it.Before(func() {
+		buffer = bytes.NewBuffer(nil)
+		logger = scribe.NewEmitter(buffer)
+
+		workingDir = t.TempDir()
+
+		var err error
+		cacheLayer, err = packit.Layers{Path: t.TempDir()}.Get("npm-cache")
+		Expect(err).NotTo(HaveOccurred())
+		cacheLayer.Metadata = map[string]interface{}{"cache_sha": "some-sha"}
+
+		now := time.Now()
+		leftpadIntegrity, leftpadContent = writeCacheEntry("make-fetch-happen:request-cache:https://registry.npmjs.org/leftpad/-/leftpad-0.0.1.tgz", "leftpad-tarball", now)
+		_, rightpadContent = writeCacheEntry("make-fetch-happen:request-cache:https://registry.npmjs.org/rightpad/-/rightpad-0.0.1.tgz", "rightpad-tarball", now)
+
+		Expect(os.WriteFile(filepath.Join(workingDir, "package-lock.json"), []byte(fmt.Sprintf(`{
+			"lockfileVersion": 3,
+			"packages": {
+				"": {"name": "some-app"},
+				"node_modules/leftpad": {"version": "0.0.1", "integrity": %q}
+			}
+		}`, leftpadIntegrity)), 0600)).To(Succeed())
+	})
+
+	context("CollectNpmCacheGarbage", func() {
+		it("removes the entries that are not referenced by the lockfile", func() {
+			layer, err := npminstall.CollectNpmCacheGarbage(logger, workingDir, cacheLayer, 0)
+			Expect(err).NotTo(HaveOccurred())
+
+			Expect(leftpadContent).To(BeARegularFile())
+			Expect(rightpadContent).NotTo(BeAnExistingFile())
+
+			buckets, err := filepath.Glob(filepath.Join(cacheLayer.Path, "_cacache", "index-v5", "*", "*", "*"))
+			Expect(err).NotTo(HaveOccurred())
+			Expect(buckets).To(HaveLen(1))
+
+			bucket, err := os.ReadFile(buckets[0])
+			Expect(err).NotTo(HaveOccurred())
+			Expect(string(bucket)).To(ContainSubstring("leftpad-0.0.1.tgz"))
+
+			Expect(layer.Metadata).To(HaveKeyWithValue("cache_sha", "some-sha"))
+			Expect(layer.Metadata).To(HaveKey("cache_size"))
+			Expect(layer.Metadata["cache_size"]).To(BeNumerically(">", 0))
+
+			Expect(buffer.String()).To(ContainSubstring("Collecting garbage in npm cache"))
+			Expect(buffer.String()).To(ContainSubstring("Removed 1 entries not referenced by package-lock.json"))
+			Expect(buffer.String()).To(MatchRegexp(`Reclaimed \d+ B, cache size is now \d+ B`))
+		})
+
+		context("when the lockfile has lockfileVersion 1", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(workingDir, "package-lock.json"), []byte(fmt.Sprintf(`{
+					"lockfileVersion": 1,
+					"dependencies": {
+						"some-package": {
+							"version": "1.0.0",
+							"dependencies": {
+								"leftpad": {"version": "0.0.1", "integrity": %q}
+							}
+						}
+					}
+				}`, leftpadIntegrity)), 0600)).To(Succeed())
+			})
+
+			it("keeps the entries referenced by nested dependencies", func() {
+				_, err := npminstall.CollectNpmCacheGarbage(logger, workingDir, cacheLayer, 0)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(leftpadContent).To(BeARegularFile())
+				Expect(rightpadContent).NotTo(BeAnExistingFile())
+			})
+		})
+
+		context("when there is no lockfile", func() {
+			it.Before(func() {
+				Expect(os.Remove(filepath.Join(workingDir, "package-lock.json"))).To(Succeed())
+			})
+
+			it("keeps all entries", func() {
+				_, err := npminstall.CollectNpmCacheGarbage(logger, workingDir, cacheLayer, 0)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(leftpadContent).To(BeARegularFile())
+				Expect(rightpadContent).To(BeARegularFile())
+				Expect(buffer.String()).To(ContainSubstring("No package-lock.json found, keeping all entries"))
+			})
+		})
+
+		context("when the cache exceeds the maximum size", func() {
+			it.Before(func() {
+				Expect(os.Remove(filepath.Join(workingDir, "package-lock.json"))).To(Succeed())
+				_, oldContent = writeCacheEntry("make-fetch-happen:request-cache:https://registry.npmjs.org/old/-/old-0.0.1.tgz", string(bytes.Repeat([]byte("x"), 4096)), time.Now().Add(-time.Hour))
+			})
+
+			it("removes the least recently written entries", func() {
+				layer, err := npminstall.CollectNpmCacheGarbage(logger, workingDir, cacheLayer, 2048)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(oldContent).NotTo(BeAnExistingFile())
+				Expect(leftpadContent).To(BeARegularFile())
+				Expect(rightpadContent).To(BeARegularFile())
+				Expect(layer.Metadata["cache_size"]).To(BeNumerically("<=", 2048))
+
+				Expect(buffer.String()).To(ContainSubstring("Removed 1 entries to stay below BP_NPM_CACHE_MAX_SIZE (2.0 KiB)"))
+			})
+
+			it("drops the fingerprint of the merged vendored npm-cache", func() {
+				layer, err := npminstall.CollectNpmCacheGarbage(logger, workingDir, cacheLayer, 2048)
+				Expect(err).NotTo(HaveOccurred())
+
+				Expect(layer.Metadata).NotTo(HaveKey("cache_sha"))
+			})
+
+			context("when no entries have to be removed", func() {
+				it("keeps the fingerprint of the merged vendored npm-cache", func() {
+					layer, err := npminstall.CollectNpmCacheGarbage(logger, workingDir, cacheLayer, 1<<20)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(oldContent).To(BeARegularFile())
+					Expect(layer.Metadata).To(HaveKeyWithValue("cache_sha", "some-sha"))
+				})
+			})
+
+			context("when the layer holds other content", func() {
+				var addon string
+
+				it.Before(func() {
+					addon = filepath.Join(cacheLayer.Path, "native-addons", "some-key", "build", "Release", "addon.node")
+					Expect(os.MkdirAll(filepath.Dir(addon), os.ModePerm)).To(Succeed())
+					Expect(os.WriteFile(addon, bytes.Repeat([]byte("x"), 8192), 0644)).To(Succeed())
+				})
+
+				it("neither counts nor removes it", func() {
+					layer, err := npminstall.CollectNpmCacheGarbage(logger, workingDir, cacheLayer, 2048)
+					Expect(err).NotTo(HaveOccurred())
+
+					Expect(addon).To(BeARegularFile())
+					Expect(oldContent).NotTo(BeAnExistingFile())
+					Expect(leftpadContent).To(BeARegularFile())
+					Expect(rightpadContent).To(BeARegularFile())
+					Expect(layer.Metadata["cache_size"]).To(BeNumerically("<=", 2048))
+				})
+			})
+		})
+
+		context("failure cases", func() {
+			context("when the lockfile cannot be parsed", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(filepath.Join(workingDir, "package-lock.json"), []byte("%%%"), 0600)).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					_, err := npminstall.CollectNpmCacheGarbage(logger, workingDir, cacheLayer, 0)
+					Expect(err).To(MatchError(ContainSubstring(`failed to parse "package-lock.json"`)))
+				})
+			})
+		})
+	})
+
+	context("LookupNpmCacheMaxSize", func() {
+		var environment *fakes.EnvironmentConfig
+
+		it.Before(func() {
+			environment = &fakes.EnvironmentConfig{}
+		})
+
+		it("returns zero when unset", func() {
+			size, err := npminstall.LookupNpmCacheMaxSize(environment)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(size).To(BeZero())
+		})
+
+		it("parses sizes with a unit", func() {
+			for value, expected := range map[string]int64{
+				"1024":  1024,
+				"512K":  512 << 10,
+				"512M":  512 << 20,
+				"2G":    2 << 30,
+				"2GiB":  2 << 30,
+				"100mb": 100 << 20,
+			} {
+				environment.LookupCall.Returns.Value = value
+				environment.LookupCall.Returns.Found = true
+
+				size, err := npminstall.LookupNpmCacheMaxSize(environment)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(size).To(Equal(expected), value)
+			}
+		})
+
+		context("when the value is invalid", func() {
+			it.Before(func() {
+				environment.LookupCall.Returns.Value = "a lot"
+				environment.LookupCall.Returns.Found = true
+			})
+
+			it("returns an error", func() {
+				_, err := npminstall.LookupNpmCacheMaxSize(environment)
+				Expect(err).To(MatchError(`invalid value for BP_NPM_CACHE_MAX_SIZE "a lot": must be a size such as "512M" or "2G"`))
+			})
+		})
+	})
+}
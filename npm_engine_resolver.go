@@ -0,0 +1,119 @@
+package npminstall
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/paketo-buildpacks/packit/v2/pexec"
+	"github.com/paketo-buildpacks/packit/v2/scribe"
+)
+
+type NpmEngineResolver struct {
+	executable  Executable
+	environment EnvironmentConfig
+	logger      scribe.Logger
+}
+
+func NewNpmEngineResolver(executable Executable, environment EnvironmentConfig, logger scribe.Logger) NpmEngineResolver {
+	return NpmEngineResolver{
+		executable:  executable,
+		environment: environment,
+		logger:      logger,
+	}
+}
+
+// Resolve compares the active npm version against the "engines.npm"
+// constraint declared in the package.json of the given project. It returns
+// the version that should be installed to satisfy the constraint, or an empty
+// string when no installation is required.
+func (r NpmEngineResolver) Resolve(projectPath string) (string, error) {
+	constraint, err := parseEnginesNpm(filepath.Join(projectPath, "package.json"))
+	if err != nil {
+		return "", err
+	}
+
+	if constraint == "" {
+		return "", nil
+	}
+
+	constraints, err := semver.NewConstraint(constraint)
+	if err != nil {
+		return "", fmt.Errorf("failed to parse engines.npm constraint %q: %w", constraint, err)
+	}
+
+	buffer := bytes.NewBuffer(nil)
+	err = r.executable.Execute(pexec.Execution{
+		Args:   []string{"--version"},
+		Dir:    projectPath,
+		Stdout: buffer,
+		Stderr: buffer,
+	})
+	if err != nil {
+		return "", fmt.Errorf("failed to execute npm --version: %w", err)
+	}
+
+	active, err := semver.NewVersion(strings.TrimSpace(buffer.String()))
+	if err != nil {
+		return "", fmt.Errorf("failed to parse npm version %q: %w", strings.TrimSpace(buffer.String()), err)
+	}
+
+	if constraints.Check(active) {
+		r.logger.Subprocess("npm %s satisfies engines.npm constraint %q", active, constraint)
+		r.logger.Break()
+		return "", nil
+	}
+
+	autoInstall, err := r.environment.LookupBool("BP_NPM_ENGINE_AUTO_INSTALL")
+	if err != nil {
+		return "", err
+	}
+
+	if autoInstall {
+		r.logger.Subprocess("npm %s does not satisfy engines.npm constraint %q", active, constraint)
+		r.logger.Break()
+		return constraint, nil
+	}
+
+	strict, err := r.environment.LookupBool("BP_NPM_ENGINE_STRICT")
+	if err != nil {
+		return "", err
+	}
+
+	if strict {
+		return "", fmt.Errorf("npm %s does not satisfy engines.npm constraint %q: set BP_NPM_VERSION or BP_NPM_ENGINE_AUTO_INSTALL=true to install a matching npm version", active, constraint)
+	}
+
+	r.logger.Subprocess("Warning: npm %s does not satisfy engines.npm constraint %q", active, constraint)
+	r.logger.Break()
+
+	return "", nil
+}
+
+func parseEnginesNpm(path string) (string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return "", nil
+		}
+		return "", fmt.Errorf(`failed to read "package.json": %w`, err)
+	}
+
+	var pkg struct {
+		Engines struct {
+			Npm string `json:"npm"`
+		} `json:"engines"`
+	}
+
+	err = json.Unmarshal(content, &pkg)
+	if err != nil {
+		return "", fmt.Errorf(`failed to parse "package.json": %w`, err)
+	}
+
+	return strings.TrimSpace(pkg.Engines.Npm), nil
+}
@@ -0,0 +1,181 @@
+package npminstall_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	npminstall "github.com/paketo-buildpacks/npm-install"
+	"github.com/paketo-buildpacks/npm-install/fakes"
+	"github.com/paketo-buildpacks/packit/v2/pexec"
+	"github.com/paketo-buildpacks/packit/v2/scribe"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+	. "github.com/paketo-buildpacks/occam/matchers"
+)
+
+func testNpmEngineResolver(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		projectPath string
+		executable  *fakes.Executable
+		environment *fakes.EnvironmentConfig
+		buffer      *bytes.Buffer
+
+		resolver npminstall.NpmEngineResolver
+	)
+
+	it.Before(func() {
+		projectPath = t.TempDir()
+		Expect(os.WriteFile(filepath.Join(projectPath, "package.json"), []byte(`{
+			"engines": {
+				"npm": "^10.0.0"
+			}
+		}`), 0600)).To(Succeed())
+
+		executable = &fakes.Executable{}
+		executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+			_, err := fmt.Fprintln(execution.Stdout, "10.2.4")
+			return err
+		}
+
+		environment = &fakes.EnvironmentConfig{}
+
+		buffer = bytes.NewBuffer(nil)
+
+		resolver = npminstall.NewNpmEngineResolver(executable, environment, scribe.NewLogger(buffer))
+	})
+
+	context("when the active npm version satisfies engines.npm", func() {
+		it("does not request an npm installation", func() {
+			version, err := resolver.Resolve(projectPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(BeEmpty())
+
+			Expect(executable.ExecuteCall.Receives.Execution.Args).To(Equal([]string{"--version"}))
+			Expect(executable.ExecuteCall.Receives.Execution.Dir).To(Equal(projectPath))
+			Expect(buffer.String()).To(ContainLines(`    npm 10.2.4 satisfies engines.npm constraint "^10.0.0"`))
+		})
+	})
+
+	context("when the package.json does not declare engines.npm", func() {
+		it.Before(func() {
+			Expect(os.WriteFile(filepath.Join(projectPath, "package.json"), []byte(`{
+				"engines": {
+					"node": "20.x"
+				}
+			}`), 0600)).To(Succeed())
+		})
+
+		it("does not check the active npm version", func() {
+			version, err := resolver.Resolve(projectPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(BeEmpty())
+			Expect(executable.ExecuteCall.CallCount).To(Equal(0))
+		})
+	})
+
+	context("when the active npm version does not satisfy engines.npm", func() {
+		it.Before(func() {
+			executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+				_, err := fmt.Fprintln(execution.Stdout, "9.8.1")
+				return err
+			}
+		})
+
+		it("logs a warning", func() {
+			version, err := resolver.Resolve(projectPath)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(version).To(BeEmpty())
+			Expect(buffer.String()).To(ContainLines(`    Warning: npm 9.8.1 does not satisfy engines.npm constraint "^10.0.0"`))
+		})
+
+		context("when BP_NPM_ENGINE_AUTO_INSTALL is true", func() {
+			it.Before(func() {
+				environment.LookupBoolCall.Stub = func(key string) (bool, error) {
+					return key == "BP_NPM_ENGINE_AUTO_INSTALL", nil
+				}
+			})
+
+			it("returns the engines.npm constraint as the version to install", func() {
+				version, err := resolver.Resolve(projectPath)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(version).To(Equal("^10.0.0"))
+			})
+		})
+
+		context("when BP_NPM_ENGINE_STRICT is true", func() {
+			it.Before(func() {
+				environment.LookupBoolCall.Stub = func(key string) (bool, error) {
+					return key == "BP_NPM_ENGINE_STRICT", nil
+				}
+			})
+
+			it("returns an error", func() {
+				_, err := resolver.Resolve(projectPath)
+				Expect(err).To(MatchError(ContainSubstring(`npm 9.8.1 does not satisfy engines.npm constraint "^10.0.0"`)))
+			})
+		})
+	})
+
+	context("failure cases", func() {
+		context("when the package.json cannot be parsed", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(projectPath, "package.json"), []byte(`%%%`), 0600)).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := resolver.Resolve(projectPath)
+				Expect(err).To(MatchError(ContainSubstring(`failed to parse "package.json"`)))
+			})
+		})
+
+		context("when the engines.npm constraint is invalid", func() {
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(projectPath, "package.json"), []byte(`{
+					"engines": {
+						"npm": "not-a-constraint"
+					}
+				}`), 0600)).To(Succeed())
+			})
+
+			it("returns an error", func() {
+				_, err := resolver.Resolve(projectPath)
+				Expect(err).To(MatchError(ContainSubstring(`failed to parse engines.npm constraint "not-a-constraint"`)))
+			})
+		})
+
+		context("when npm --version fails", func() {
+			it.Before(func() {
+				executable.ExecuteCall.Stub = func(pexec.Execution) error {
+					return errors.New("failed to execute")
+				}
+			})
+
+			it("returns an error", func() {
+				_, err := resolver.Resolve(projectPath)
+				Expect(err).To(MatchError("failed to execute npm --version: failed to execute"))
+			})
+		})
+
+		context("when the strict flag cannot be parsed", func() {
+			it.Before(func() {
+				executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+					_, err := fmt.Fprintln(execution.Stdout, "9.8.1")
+					return err
+				}
+				environment.LookupBoolCall.Returns.Error = errors.New("failed to parse")
+			})
+
+			it("returns an error", func() {
+				_, err := resolver.Resolve(projectPath)
+				Expect(err).To(MatchError("failed to parse"))
+			})
+		})
+	})
+}
@@ -0,0 +1,234 @@
+package npminstall
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/paketo-buildpacks/packit/v2/pexec"
+)
+
+// npmTimeoutTailLines is the number of trailing lines of output included in
+// the error of an invocation that timed out.
+const npmTimeoutTailLines = 20
+
+// npmInterruptGracePeriod is how long npm gets to exit after SIGTERM when the
+// build is interrupted, before its process group is killed.
+const npmInterruptGracePeriod = 5 * time.Second
+
+// NpmExecutable runs npm in a process group of its own so that the whole
+// process tree, including lifecycle scripts and node-gyp, can be killed once
+// an invocation exceeds the timeout or the build is interrupted. A zero
+// timeout disables the limit.
+type NpmExecutable struct {
+	name      string
+	timeout   time.Duration
+	interrupt Interrupt
+}
+
+func NewNpmExecutable(name string, timeout time.Duration) NpmExecutable {
+	return NpmExecutable{
+		name:    name,
+		timeout: timeout,
+	}
+}
+
+// WithInterrupt returns a copy of the executable that stops the running npm
+// once the given interrupt is triggered.
+func (e NpmExecutable) WithInterrupt(interrupt Interrupt) NpmExecutable {
+	e.interrupt = interrupt
+	return e
+}
+
+// LookupNpmTimeout returns the timeout of a single npm invocation as
+// configured by BP_NPM_TIMEOUT. A missing value disables the timeout.
+func LookupNpmTimeout(environment EnvironmentConfig) (time.Duration, error) {
+	value, ok := environment.Lookup("BP_NPM_TIMEOUT")
+	if !ok {
+		return 0, nil
+	}
+
+	timeout, err := time.ParseDuration(value)
+	if err != nil || timeout < 0 {
+		return 0, fmt.Errorf("invalid value for BP_NPM_TIMEOUT %q: must be a non-negative duration such as \"15m\"", value)
+	}
+
+	return timeout, nil
+}
+
+func (e NpmExecutable) Execute(execution pexec.Execution) error {
+	err := e.interrupt.Err()
+	if err != nil {
+		return err
+	}
+
+	path, err := e.lookPath(execution.Env)
+	if err != nil {
+		return err
+	}
+
+	command := strings.Join(append([]string{filepath.Base(e.name)}, execution.Args...), " ")
+	tail := newOutputTail(npmTimeoutTailLines)
+
+	cmd := exec.Command(path, execution.Args...)
+	cmd.Dir = execution.Dir
+	if len(execution.Env) > 0 {
+		cmd.Env = execution.Env
+	}
+	cmd.Stdin = execution.Stdin
+	cmd.Stdout = io.MultiWriter(writerOrDiscard(execution.Stdout), tail)
+	cmd.Stderr = io.MultiWriter(writerOrDiscard(execution.Stderr), tail)
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	// Processes that escape the process group may keep the output pipes
+	// open, so stop waiting for them shortly after npm itself exits.
+	cmd.WaitDelay = time.Second
+
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- cmd.Wait()
+	}()
+
+	var expired <-chan time.Time
+	if e.timeout > 0 {
+		timer := time.NewTimer(e.timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	select {
+	case err := <-done:
+		return err
+	case <-e.interrupt.Done():
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGTERM)
+
+		grace := time.NewTimer(npmInterruptGracePeriod)
+		defer grace.Stop()
+
+		select {
+		case <-done:
+		case <-grace.C:
+			_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			<-done
+		}
+
+		return fmt.Errorf("'%s' was stopped: %w", command, e.interrupt.Err())
+	case <-expired:
+		_ = syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		<-done
+
+		return npmTimeoutError{
+			command: command,
+			timeout: e.timeout,
+			output:  tail.Lines(),
+		}
+	}
+}
+
+// lookPath resolves the executable against the PATH of the execution
+// environment, falling back to the PATH of the buildpack process.
+func (e NpmExecutable) lookPath(env []string) (string, error) {
+	if strings.Contains(e.name, string(os.PathSeparator)) {
+		return exec.LookPath(e.name)
+	}
+
+	for _, variable := range env {
+		if path, ok := strings.CutPrefix(variable, "PATH="); ok && path != "" {
+			for _, dir := range filepath.SplitList(path) {
+				if executable, err := exec.LookPath(filepath.Join(dir, e.name)); err == nil {
+					return executable, nil
+				}
+			}
+		}
+	}
+
+	return exec.LookPath(e.name)
+}
+
+func writerOrDiscard(writer io.Writer) io.Writer {
+	if writer == nil {
+		return io.Discard
+	}
+
+	return writer
+}
+
+type npmTimeoutError struct {
+	command string
+	timeout time.Duration
+	output  []string
+}
+
+func (e npmTimeoutError) Error() string {
+	message := fmt.Sprintf("'%s' timed out after %s and was killed", e.command, e.timeout)
+	if len(e.output) == 0 {
+		return message
+	}
+
+	return fmt.Sprintf("%s, last lines of output:\n  %s", message, strings.Join(e.output, "\n  "))
+}
+
+// outputTail keeps the last lines written to it.
+type outputTail struct {
+	mutex   sync.Mutex
+	limit   int
+	lines   []string
+	partial []byte
+}
+
+func newOutputTail(limit int) *outputTail {
+	return &outputTail{limit: limit}
+}
+
+func (t *outputTail) Write(p []byte) (int, error) {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	t.partial = append(t.partial, p...)
+	for {
+		index := bytes.IndexByte(t.partial, '\n')
+		if index < 0 {
+			break
+		}
+
+		t.append(string(t.partial[:index]))
+		t.partial = t.partial[index+1:]
+	}
+
+	return len(p), nil
+}
+
+func (t *outputTail) append(line string) {
+	t.lines = append(t.lines, strings.TrimRight(line, "\r"))
+	if len(t.lines) > t.limit {
+		t.lines = t.lines[len(t.lines)-t.limit:]
+	}
+}
+
+// Lines returns the retained lines, including an unterminated last line.
+func (t *outputTail) Lines() []string {
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+
+	lines := append([]string(nil), t.lines...)
+	if len(t.partial) > 0 {
+		lines = append(lines, string(t.partial))
+		if len(lines) > t.limit {
+			lines = lines[len(lines)-t.limit:]
+		}
+	}
+
+	return lines
+}
@@ -0,0 +1,188 @@
+package npminstall_test
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	npminstall "github.com/paketo-buildpacks/npm-install"
+	"github.com/paketo-buildpacks/npm-install/fakes"
+	"github.com/paketo-buildpacks/packit/v2/pexec"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testNpmExecutable(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		binDir     string
+		workingDir string
+		stdout     *bytes.Buffer
+		stderr     *bytes.Buffer
+	)
+
+	it.Before(func() {
+		binDir = t.TempDir()
+		workingDir = t.TempDir()
+		stdout = bytes.NewBuffer(nil)
+		stderr = bytes.NewBuffer(nil)
+
+		Expect(os.WriteFile(filepath.Join(binDir, "npm"), []byte(`#!/bin/sh
+echo "args: $*"
+case "$1" in
+  hang)
+    for i in $(seq 1 30); do echo "line $i"; done
+    (sleep 1; touch "$PWD/grandchild-survived") &
+    sleep 10
+    ;;
+  fail)
+    exit 3
+    ;;
+  *)
+    echo "some error" >&2
+    ;;
+esac
+`), 0755)).To(Succeed())
+	})
+
+	context("Execute", func() {
+		it("runs the executable from the PATH of the execution", func() {
+			executable := npminstall.NewNpmExecutable("npm", time.Minute)
+
+			err := executable.Execute(pexec.Execution{
+				Args:   []string{"ci", "--ignore-scripts"},
+				Dir:    workingDir,
+				Env:    []string{fmt.Sprintf("PATH=%s:%s", binDir, os.Getenv("PATH"))},
+				Stdout: stdout,
+				Stderr: stderr,
+			})
+			Expect(err).NotTo(HaveOccurred())
+			Expect(stdout.String()).To(Equal("args: ci --ignore-scripts\n"))
+			Expect(stderr.String()).To(Equal("some error\n"))
+		})
+
+		context("when the executable fails", func() {
+			it("returns its exit error", func() {
+				executable := npminstall.NewNpmExecutable(filepath.Join(binDir, "npm"), 0)
+
+				err := executable.Execute(pexec.Execution{
+					Args: []string{"fail"},
+					Dir:  workingDir,
+				})
+				Expect(err).To(MatchError("exit status 3"))
+			})
+		})
+
+		context("when the invocation exceeds the timeout", func() {
+			it("kills the whole process group and reports the last lines of output", func() {
+				executable := npminstall.NewNpmExecutable(filepath.Join(binDir, "npm"), 200*time.Millisecond)
+
+				start := time.Now()
+				err := executable.Execute(pexec.Execution{
+					Args:   []string{"hang", "--some-flag"},
+					Dir:    workingDir,
+					Stdout: stdout,
+					Stderr: stderr,
+				})
+				Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+
+				Expect(err).To(MatchError(ContainSubstring("'npm hang --some-flag' timed out after 200ms and was killed, last lines of output:\n  line 11\n")))
+				Expect(err).To(MatchError(HaveSuffix("\n  line 30")))
+				Expect(err).NotTo(MatchError(ContainSubstring("line 10\n")))
+				Expect(stdout.String()).To(ContainSubstring("line 1\n"))
+
+				time.Sleep(1500 * time.Millisecond)
+				Expect(filepath.Join(workingDir, "grandchild-survived")).NotTo(BeAnExistingFile())
+			})
+		})
+
+		context("when the build is interrupted", func() {
+			it("stops the whole process group and returns an interrupted error", func() {
+				interrupt := npminstall.NewInterrupt()
+				executable := npminstall.NewNpmExecutable(filepath.Join(binDir, "npm"), 0).WithInterrupt(interrupt)
+
+				time.AfterFunc(200*time.Millisecond, func() { interrupt.Trigger(syscall.SIGTERM) })
+
+				start := time.Now()
+				err := executable.Execute(pexec.Execution{
+					Args: []string{"hang"},
+					Dir:  workingDir,
+				})
+				Expect(time.Since(start)).To(BeNumerically("<", 5*time.Second))
+				Expect(err).To(MatchError("'npm hang' was stopped: build interrupted by terminated"))
+				Expect(errors.Is(err, npminstall.ErrInterrupted)).To(BeTrue())
+
+				time.Sleep(1500 * time.Millisecond)
+				Expect(filepath.Join(workingDir, "grandchild-survived")).NotTo(BeAnExistingFile())
+			})
+
+			context("before npm starts", func() {
+				it("does not run it", func() {
+					interrupt := npminstall.NewInterrupt()
+					interrupt.Trigger(syscall.SIGINT)
+					executable := npminstall.NewNpmExecutable(filepath.Join(binDir, "npm"), 0).WithInterrupt(interrupt)
+
+					err := executable.Execute(pexec.Execution{
+						Args:   []string{"ci"},
+						Dir:    workingDir,
+						Stdout: stdout,
+					})
+					Expect(err).To(MatchError("build interrupted by interrupt"))
+					Expect(stdout.String()).To(BeEmpty())
+				})
+			})
+		})
+
+		context("when the executable cannot be found", func() {
+			it("returns an error", func() {
+				executable := npminstall.NewNpmExecutable("no-such-npm", 0)
+
+				err := executable.Execute(pexec.Execution{})
+				Expect(err).To(MatchError(ContainSubstring(`"no-such-npm": executable file not found in $PATH`)))
+			})
+		})
+	})
+
+	context("LookupNpmTimeout", func() {
+		var environment *fakes.EnvironmentConfig
+
+		it.Before(func() {
+			environment = &fakes.EnvironmentConfig{}
+		})
+
+		it("parses the configured duration", func() {
+			environment.LookupCall.Returns.Value = "15m"
+			environment.LookupCall.Returns.Found = true
+
+			timeout, err := npminstall.LookupNpmTimeout(environment)
+			Expect(err).NotTo(HaveOccurred())
+			Expect(timeout).To(Equal(15 * time.Minute))
+			Expect(environment.LookupCall.Receives.Key).To(Equal("BP_NPM_TIMEOUT"))
+		})
+
+		context("when BP_NPM_TIMEOUT is not set", func() {
+			it("disables the timeout", func() {
+				timeout, err := npminstall.LookupNpmTimeout(environment)
+				Expect(err).NotTo(HaveOccurred())
+				Expect(timeout).To(BeZero())
+			})
+		})
+
+		context("when BP_NPM_TIMEOUT is invalid", func() {
+			it("returns an error", func() {
+				environment.LookupCall.Returns.Value = "forever"
+				environment.LookupCall.Returns.Found = true
+
+				_, err := npminstall.LookupNpmTimeout(environment)
+				Expect(err).To(MatchError(`invalid value for BP_NPM_TIMEOUT "forever": must be a non-negative duration such as "15m"`))
+			})
+		})
+	})
+}
@@ -0,0 +1,103 @@
+package npminstall
+
+import (
+	"bytes"
+	"fmt"
+
+	"github.com/paketo-buildpacks/packit/v2/scribe"
+)
+
+// npmFailure decorates the error of a failed npm invocation with a summary
+// of the recognised npm error and a suggested fix.
+type npmFailure struct {
+	err     error
+	code    string
+	summary string
+	hint    string
+}
+
+func (f npmFailure) Error() string {
+	return fmt.Sprintf("%s: %s (%s): %s", f.err, f.summary, f.code, f.hint)
+}
+
+func (f npmFailure) Unwrap() error {
+	return f.err
+}
+
+// diagnoseNpmFailure recognises common npm errors in the output of a failed
+// invocation. It logs a concise summary with a suggested fix and returns the
+// error decorated with the same information. Unrecognised failures are
+// returned unchanged.
+func diagnoseNpmFailure(logger scribe.Logger, output []byte, err error) error {
+	failure, ok := recogniseNpmFailure(output)
+	if !ok {
+		return err
+	}
+
+	failure.err = err
+
+	logger.Break()
+	logger.Subprocess("npm failed with %s: %s", failure.code, failure.summary)
+	logger.Action("Hint: %s", failure.hint)
+	logger.Break()
+
+	return failure
+}
+
+func recogniseNpmFailure(output []byte) (npmFailure, bool) {
+	if bytes.Contains(output, []byte("gyp ERR!")) {
+		if bytes.Contains(output, []byte("find Python")) {
+			return npmFailure{
+				code:    "gyp ERR!",
+				summary: "node-gyp could not find Python to compile a native module",
+				hint:    "set BP_NPM_INCLUDE_BUILD_PYTHON=true to make Python available during the build",
+			}, true
+		}
+
+		return npmFailure{
+			code:    "gyp ERR!",
+			summary: "a native module failed to compile",
+			hint:    "check the node-gyp output above; set BP_NPM_INCLUDE_BUILD_PYTHON=true if Python is missing or use a builder that ships a C/C++ toolchain",
+		}, true
+	}
+
+	code, _ := classifyNpmFailure(output)
+	switch {
+	case code == "EUSAGE" && bytes.Contains(output, []byte("in sync")):
+		return npmFailure{
+			code:    code,
+			summary: "package.json and package-lock.json are out of sync",
+			hint:    "run 'npm install' locally and commit the updated package-lock.json",
+		}, true
+
+	case code == "ERESOLVE":
+		return npmFailure{
+			code:    code,
+			summary: "the dependency tree could not be resolved because of conflicting peer dependencies",
+			hint:    "fix the conflicting versions in package.json or add 'legacy-peer-deps=true' to the .npmrc of the application",
+		}, true
+
+	case code == "E401" || code == "E403":
+		return npmFailure{
+			code:    code,
+			summary: "the registry rejected the request as unauthorized",
+			hint:    "add an npmrc binding with a valid auth token for the registry",
+		}, true
+
+	case code == "ENOTFOUND":
+		return npmFailure{
+			code:    code,
+			summary: "the registry host could not be resolved",
+			hint:    "check the registry configured in .npmrc and the network and proxy settings of the build environment",
+		}, true
+
+	case code == "EINTEGRITY":
+		return npmFailure{
+			code:    code,
+			summary: "a downloaded package does not match the integrity checksum in package-lock.json",
+			hint:    "regenerate package-lock.json with 'npm install' and check that the registry serves the expected tarballs",
+		}, true
+	}
+
+	return npmFailure{}, false
+}
@@ -0,0 +1,96 @@
+package npminstall
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/paketo-buildpacks/packit/v2/pexec"
+	"github.com/paketo-buildpacks/packit/v2/scribe"
+)
+
+var npmErrorCodePattern = regexp.MustCompile(`(?m)^npm (?:ERR!|error) code (\S+)`)
+
+// transientNpmErrors are the network failures that are worth retrying.
+var transientNpmErrors = []string{"ECONNRESET", "ETIMEDOUT", "ESOCKETTIMEDOUT", "ECONNREFUSED", "EAI_AGAIN", "EPIPE"}
+
+// classifyNpmFailure inspects the output of a failed npm invocation and
+// returns the npm error code along with whether the failure is transient.
+// Network errors and registry 5xx responses are transient; everything else,
+// e.g. ERESOLVE, E401 or E404, is not.
+func classifyNpmFailure(output []byte) (string, bool) {
+	var code string
+	if matches := npmErrorCodePattern.FindSubmatch(output); matches != nil {
+		code = string(matches[1])
+	}
+
+	for _, transient := range transientNpmErrors {
+		if code == transient {
+			return code, true
+		}
+	}
+
+	if len(code) == 4 && strings.HasPrefix(code, "E5") {
+		if _, err := strconv.Atoi(code[1:]); err == nil {
+			return code, true
+		}
+	}
+
+	if code == "" {
+		for _, transient := range append(transientNpmErrors, "socket hang up") {
+			if bytes.Contains(output, []byte(transient)) {
+				return transient, true
+			}
+		}
+	}
+
+	return code, false
+}
+
+// lookupNpmRetries returns the number of times a transient npm failure is
+// retried, as configured by BP_NPM_RETRIES.
+func lookupNpmRetries(environment EnvironmentConfig) (int, error) {
+	value, ok := environment.Lookup("BP_NPM_RETRIES")
+	if !ok {
+		return 0, nil
+	}
+
+	retries, err := strconv.Atoi(value)
+	if err != nil || retries < 0 {
+		return 0, fmt.Errorf("invalid value for BP_NPM_RETRIES %q: must be a non-negative integer", value)
+	}
+
+	return retries, nil
+}
+
+// executeWithRetries runs the given npm execution and retries it up to
+// retries times with exponential backoff, starting at delay, as long as it
+// fails with a transient error. The final failure is diagnosed with
+// diagnoseNpmFailure.
+func executeWithRetries(executable Executable, logger scribe.Logger, retries int, delay time.Duration, execution pexec.Execution) error {
+	stdout, stderr := execution.Stdout, execution.Stderr
+
+	for attempt := 0; ; attempt++ {
+		output := bytes.NewBuffer(nil)
+		execution.Stdout = io.MultiWriter(stdout, output)
+		execution.Stderr = io.MultiWriter(stderr, output)
+
+		err := executable.Execute(execution)
+		if err == nil {
+			return nil
+		}
+
+		code, transient := classifyNpmFailure(output.Bytes())
+		if !transient || attempt >= retries {
+			return diagnoseNpmFailure(logger, output.Bytes(), err)
+		}
+
+		wait := delay << attempt
+		logger.Subprocess("npm failed with transient error %s, retrying in %s (attempt %d of %d)", code, wait, attempt+2, retries+1)
+		time.Sleep(wait)
+	}
+}
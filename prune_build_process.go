@@ -23,8 +23,8 @@ type PruneBuildProcess struct {
 	logger      scribe.Logger
 }
 
-func (r PruneBuildProcess) ShouldRun(workingDir string, metadata map[string]interface{}, npmrcPath string) (bool, string, error) {
-	return true, "", nil
+func (r PruneBuildProcess) ShouldRun(workingDir string, metadata map[string]interface{}, npmrcPath string) (bool, map[string]interface{}, error) {
+	return true, nil, nil
 }
 
 func (r PruneBuildProcess) Run(modulesDir, cacheDir, workingDir, npmrcPath string, launch bool) error {
@@ -70,10 +70,10 @@ func testPruneBuildProcess(t *testing.T, context spec.G, it spec.S) {
 
 	context("ShouldRun", func() {
 		it("returns true", func() {
-			run, sha, err := process.ShouldRun(workingDir, nil, "some-npmrc-path")
+			run, metadata, err := process.ShouldRun(workingDir, nil, "some-npmrc-path")
 			Expect(err).NotTo(HaveOccurred())
 			Expect(run).To(BeTrue())
-			Expect(sha).To(BeEmpty())
+			Expect(metadata).To(BeNil())
 		})
 	})
 
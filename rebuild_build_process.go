@@ -1,12 +1,14 @@
 package npminstall
 
 import (
+	"bytes"
 	"errors"
 	"fmt"
 	"os"
 	"path/filepath"
 	"strings"
 
+	"github.com/paketo-buildpacks/packit/v2/chronos"
 	"github.com/paketo-buildpacks/packit/v2/fs"
 	"github.com/paketo-buildpacks/packit/v2/pexec"
 	"github.com/paketo-buildpacks/packit/v2/scribe"
@@ -16,19 +18,21 @@ type RebuildBuildProcess struct {
 	executable  Executable
 	summer      Summer
 	environment EnvironmentConfig
+	clock       chronos.Clock
 	logger      scribe.Logger
 }
 
-func NewRebuildBuildProcess(executable Executable, summer Summer, environment EnvironmentConfig, logger scribe.Logger) RebuildBuildProcess {
+func NewRebuildBuildProcess(executable Executable, summer Summer, environment EnvironmentConfig, clock chronos.Clock, logger scribe.Logger) RebuildBuildProcess {
 	return RebuildBuildProcess{
 		executable:  executable,
 		summer:      summer,
 		environment: environment,
+		clock:       clock,
 		logger:      logger,
 	}
 }
 
-func (r RebuildBuildProcess) ShouldRun(workingDir string, metadata map[string]interface{}, npmrcPath string) (bool, string, error) {
+func (r RebuildBuildProcess) ShouldRun(workingDir string, metadata map[string]interface{}, npmrcPath string) (bool, map[string]interface{}, error) {
 	cachedNodeVersion, err := cacheExecutableResponse(
 		r.executable,
 		[]string{"get", "user-agent"},
@@ -36,7 +40,7 @@ func (r RebuildBuildProcess) ShouldRun(workingDir string, metadata map[string]in
 		npmrcPath,
 		r.logger)
 	if err != nil {
-		return false, "", fmt.Errorf("failed to execute npm get user-agent: %w", err)
+		return false, nil, fmt.Errorf("failed to execute npm get user-agent: %w", err)
 	}
 	defer func() {
 		if removeErr := os.Remove(cachedNodeVersion); removeErr != nil {
@@ -44,34 +48,65 @@ func (r RebuildBuildProcess) ShouldRun(workingDir string, metadata map[string]in
 		}
 	}()
 
-	sum, err := r.summer.Sum(filepath.Join(workingDir, "node_modules"), cachedNodeVersion)
+	cachedNpmConfiguration, err := cacheNpmConfiguration(workingDir, npmrcPath)
 	if err != nil {
-		return false, "", err
+		return false, nil, fmt.Errorf("failed to determine npm configuration: %w", err)
 	}
+	defer func() {
+		if removeErr := os.Remove(cachedNpmConfiguration); removeErr != nil {
+			r.logger.Subprocess("Warning: failed to remove temporary file %s: %s", cachedNpmConfiguration, removeErr)
+		}
+	}()
+
+	return checkCacheInputs(r.summer, r.logger, metadata, []cacheInput{
+		{name: "node_modules", path: filepath.Join(workingDir, "node_modules")},
+		{name: "npm-user-agent", path: cachedNodeVersion},
+		{name: "npmrc", path: cachedNpmConfiguration},
+	})
+}
 
-	cacheSha, ok := metadata["cache_sha"].(string)
-	if !ok || sum != cacheSha {
-		return true, sum, nil
+func (r RebuildBuildProcess) Run(modulesDir, cacheDir, workingDir, npmrcPath, nodeHeadersPath, arch string, launch bool) error {
+	lockfilePath := filepath.Join(workingDir, "package-lock.json")
+	locked, err := fs.Exists(lockfilePath)
+	if err != nil {
+		return err
 	}
 
-	return false, "", nil
-}
+	if locked {
+		err = checkLockfileVersion(r.environment, lockfilePath)
+		if err != nil {
+			return err
+		}
+	}
 
-func (r RebuildBuildProcess) Run(modulesDir, cacheDir, workingDir, npmrcPath string, launch bool) error {
 	environment := os.Environ()
 	if npmrcPath != "" {
 		environment = append(environment, fmt.Sprintf("NPM_CONFIG_GLOBALCONFIG=%s", npmrcPath))
 	}
 
-	err := r.executable.Execute(pexec.Execution{
-		Args:   []string{"list"},
+	listing := bytes.NewBuffer(nil)
+	err = r.executable.Execute(pexec.Execution{
+		Args:   []string{"ls", "--json", "--all"},
 		Dir:    workingDir,
 		Env:    environment,
-		Stdout: r.logger.ActionWriter,
+		Stdout: listing,
 		Stderr: r.logger.ActionWriter,
 	})
 	if err != nil {
-		return fmt.Errorf("vendored node_modules have unmet dependencies: npm list failed: %w", err)
+		problems, parseErr := parseDependencyReport(listing.Bytes())
+		if parseErr != nil || len(problems) == 0 {
+			return fmt.Errorf("vendored node_modules have unmet dependencies: npm ls failed: %w", err)
+		}
+
+		var report []string
+		r.logger.Subprocess("Vendored node_modules have unmet dependencies:")
+		for _, problem := range problems {
+			r.logger.Action("%s", problem)
+			report = append(report, fmt.Sprintf("  %s", problem))
+		}
+		r.logger.Break()
+
+		return fmt.Errorf("vendored node_modules have unmet dependencies:\n%s", strings.Join(report, "\n"))
 	}
 
 	args := []string{"run-script", "preinstall", "--if-present"}
@@ -97,18 +132,64 @@ func (r RebuildBuildProcess) Run(modulesDir, cacheDir, workingDir, npmrcPath str
 		env = append(env, "NODE_ENV=development")
 	}
 
-	nodeHome, _ := r.environment.Lookup("NODE_HOME")
-	args = []string{"rebuild", fmt.Sprintf("--nodedir=%s", nodeHome)}
-	r.logger.Subprocess("Running 'npm %s'", strings.Join(args, " "))
-	err = r.executable.Execute(pexec.Execution{
-		Args:   args,
-		Dir:    workingDir,
-		Stdout: r.logger.ActionWriter,
-		Stderr: r.logger.ActionWriter,
-		Env:    env,
-	})
+	targets, err := findRebuildTargets(filepath.Join(workingDir, "node_modules"))
 	if err != nil {
-		return fmt.Errorf("npm rebuild failed: %s", err)
+		return err
+	}
+
+	foreign, err := findForeignBinaries(filepath.Join(workingDir, "node_modules"), arch)
+	if err != nil {
+		return err
+	}
+
+	if len(foreign) > 0 {
+		r.logger.Subprocess("Vendored node_modules contain binaries built for another platform, forcing a rebuild:")
+		for _, binary := range foreign {
+			r.logger.Action("%s", binary)
+			targets = addRebuildTarget(targets, binary.Package, binary.PackagePath)
+		}
+		r.logger.Break()
+	}
+
+	if len(targets) == 0 {
+		r.logger.Subprocess("Skipping 'npm rebuild': no packages with native code or install scripts found")
+	}
+
+	if len(targets) > 0 {
+		nodeHome, _ := r.environment.Lookup("NODE_HOME")
+		headers := nodeHeaders{nodeDir: nodeHome, tarball: nodeHeadersPath}
+		if compilesNativeCode(targets) {
+			headers, err = resolveNodeHeaders(nodeHome, nodeHeadersPath)
+			if err != nil {
+				return err
+			}
+		}
+
+		cache, err := newNativeAddonCache(cacheDir, workingDir, nodeHome, arch)
+		if err != nil {
+			return err
+		}
+
+		err = buildNativeAddons(r.executable, r.logger, r.clock, cache, headers, targets, workingDir, env)
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(foreign) > 0 {
+		foreign, err = findForeignBinaries(filepath.Join(workingDir, "node_modules"), arch)
+		if err != nil {
+			return err
+		}
+
+		if len(foreign) > 0 {
+			var report []string
+			for _, binary := range foreign {
+				report = append(report, fmt.Sprintf("  %s", binary))
+			}
+
+			return fmt.Errorf("vendored node_modules contain binaries built for another platform that were not replaced by 'npm rebuild':\n%s\nremove node_modules from the application source or vendor dependencies built for linux/%s", strings.Join(report, "\n"), arch)
+		}
 	}
 
 	args = []string{"run-script", "postinstall", "--if-present"}
@@ -6,11 +6,14 @@ import (
 	"fmt"
 	"os"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"testing"
+	"time"
 
 	npminstall "github.com/paketo-buildpacks/npm-install"
 	"github.com/paketo-buildpacks/npm-install/fakes"
+	"github.com/paketo-buildpacks/packit/v2/chronos"
 	"github.com/paketo-buildpacks/packit/v2/pexec"
 	"github.com/paketo-buildpacks/packit/v2/scribe"
 	"github.com/sclevine/spec"
@@ -32,6 +35,8 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 		executable  *fakes.Executable
 		summer      *fakes.Summer
 		environment *fakes.EnvironmentConfig
+		clock       chronos.Clock
+		nodeHome    string
 
 		buffer *bytes.Buffer
 
@@ -54,6 +59,14 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 		err = os.WriteFile(filepath.Join(workingDir, "node_modules", "some-module", "some-file"), []byte("some-content"), 0644)
 		Expect(err).NotTo(HaveOccurred())
 
+		err = os.WriteFile(filepath.Join(workingDir, "node_modules", "some-module", "package.json"), []byte(`{
+			"name": "some-module",
+			"scripts": {
+				"install": "node-gyp rebuild"
+			}
+		}`), 0644)
+		Expect(err).NotTo(HaveOccurred())
+
 		executable = &fakes.Executable{}
 		executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
 			executions = append(executions, execution)
@@ -68,19 +81,31 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 
 		summer = &fakes.Summer{}
 
+		nodeHome = t.TempDir()
+		Expect(os.MkdirAll(filepath.Join(nodeHome, "include", "node"), os.ModePerm)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(nodeHome, "include", "node", "common.gypi"), nil, 0600)).To(Succeed())
+
 		environment = &fakes.EnvironmentConfig{}
 		environment.LookupCall.Stub = func(key string) (string, bool) {
 			switch key {
 			case "NPM_CONFIG_LOGLEVEL":
 				return "some-val", true
+			case "NODE_HOME":
+				return nodeHome, true
 			default:
 				return "", false
 			}
 		}
 
+		now := time.Now()
+		clock = chronos.NewClock(func() time.Time {
+			now = now.Add(time.Second)
+			return now
+		})
+
 		buffer = bytes.NewBuffer(nil)
 
-		process = npminstall.NewRebuildBuildProcess(executable, summer, environment, scribe.NewLogger(buffer))
+		process = npminstall.NewRebuildBuildProcess(executable, summer, environment, clock, scribe.NewLogger(buffer))
 	})
 
 	it.After(func() {
@@ -90,20 +115,42 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 	})
 
 	context("ShouldRun", func() {
-		context("when the checksum matches the layer metadata shasum", func() {
-			it.Before(func() {
-				summer.SumCall.Returns.String = "some-cache-sha"
-			})
+		var summedPaths []string
+
+		it.Before(func() {
+			summedPaths = nil
+			summer.SumCall.Stub = func(paths ...string) (string, error) {
+				summedPaths = append(summedPaths, paths...)
+
+				switch {
+				case strings.Contains(paths[0], "executable_response"):
+					return "user-agent-sha", nil
+				case strings.Contains(paths[0], "npm_configuration"):
+					return "npmrc-sha", nil
+				default:
+					return fmt.Sprintf("%s-sha", filepath.Base(paths[0])), nil
+				}
+			}
+		})
 
-			it("returns false", func() {
-				run, sha, err := process.ShouldRun(workingDir, map[string]interface{}{
-					"cache_sha": "some-cache-sha",
-				}, "some-npmrc-path")
+		context("when the layer metadata does not have a checksum", func() {
+			it("returns true and the metadata for each input", func() {
+				run, metadata, err := process.ShouldRun(workingDir, nil, "some-npmrc-path")
 				Expect(err).NotTo(HaveOccurred())
-				Expect(run).To(BeFalse())
-				Expect(sha).To(BeEmpty())
-				Expect(summer.SumCall.Receives.Paths[0]).To(Equal(filepath.Join(workingDir, "node_modules")))
-				Expect(summer.SumCall.Receives.Paths[1]).To(ContainSubstring("executable_response"))
+				Expect(run).To(BeTrue())
+
+				Expect(summedPaths).To(HaveLen(3))
+				Expect(summedPaths[0]).To(Equal(filepath.Join(workingDir, "node_modules")))
+				Expect(summedPaths[1]).To(ContainSubstring("executable_response"))
+				Expect(summedPaths[2]).To(ContainSubstring("npm_configuration"))
+
+				Expect(metadata).To(HaveKeyWithValue("cache_sha", Not(BeEmpty())))
+				Expect(metadata).To(HaveKeyWithValue("inputs", map[string]interface{}{
+					"node_modules":   "node_modules-sha",
+					"npm-user-agent": "user-agent-sha",
+					"npmrc":          "npmrc-sha",
+				}))
+
 				for _, ex := range executions {
 					Expect(ex.Env).To(Equal(append(os.Environ(), "NPM_CONFIG_GLOBALCONFIG=some-npmrc-path")))
 				}
@@ -116,51 +163,44 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 			})
 		})
 
-		context("when the checksum does not match the layer metadata shasum", func() {
+		context("when the checksum matches the layer metadata shasum", func() {
+			var previous map[string]interface{}
+
 			it.Before(func() {
-				summer.SumCall.Returns.String = "other-cache-sha"
+				var err error
+				_, previous, err = process.ShouldRun(workingDir, nil, "some-npmrc-path")
+				Expect(err).NotTo(HaveOccurred())
 			})
 
 			it("returns false", func() {
-				run, sha, err := process.ShouldRun(workingDir, map[string]interface{}{
-					"cache_sha": "some-cache-sha",
-				}, "some-npmrc-path")
+				run, metadata, err := process.ShouldRun(workingDir, previous, "some-npmrc-path")
 				Expect(err).NotTo(HaveOccurred())
-				Expect(run).To(BeTrue())
-				Expect(sha).To(Equal("other-cache-sha"))
-				Expect(summer.SumCall.Receives.Paths[0]).To(Equal(filepath.Join(workingDir, "node_modules")))
-				Expect(summer.SumCall.Receives.Paths[1]).To(ContainSubstring("executable_response"))
-				for _, ex := range executions {
-					Expect(ex.Env).To(Equal(append(os.Environ(), "NPM_CONFIG_GLOBALCONFIG=some-npmrc-path")))
-				}
-				lastExecution := executions[len(executions)-1]
-				Expect(lastExecution.Args).To(Equal([]string{
-					"get",
-					"user-agent",
-				}))
-				Expect(lastExecution.Dir).To(Equal(workingDir))
+				Expect(run).To(BeFalse())
+				Expect(metadata).To(BeNil())
 			})
 		})
 
-		context("when the layer metadata does not have a checksum", func() {
-			it.Before(func() {
-				summer.SumCall.Returns.String = "other-cache-sha"
-			})
-
-			it("returns false", func() {
-				run, sha, err := process.ShouldRun(workingDir, nil, "")
+		context("when the checksum does not match the layer metadata shasum", func() {
+			it("returns true and logs the inputs that changed", func() {
+				run, _, err := process.ShouldRun(workingDir, map[string]interface{}{
+					"cache_sha": "some-cache-sha",
+					"inputs": map[string]interface{}{
+						"node_modules":   "other-node_modules-sha",
+						"npm-user-agent": "user-agent-sha",
+						"npmrc":          "npmrc-sha",
+					},
+				}, "some-npmrc-path")
 				Expect(err).NotTo(HaveOccurred())
 				Expect(run).To(BeTrue())
-				Expect(sha).To(Equal("other-cache-sha"))
 
-				Expect(summer.SumCall.Receives.Paths[0]).To(Equal(filepath.Join(workingDir, "node_modules")))
-				Expect(summer.SumCall.Receives.Paths[1]).To(ContainSubstring("executable_response"))
+				Expect(buffer.String()).To(ContainSubstring("Cache invalidated by changes to: node_modules"))
 			})
 		})
 
 		context("failure cases", func() {
 			context("when the there is an error in the checksummer process", func() {
 				it.Before(func() {
+					summer.SumCall.Stub = nil
 					summer.SumCall.Returns.Error = errors.New("checksummer error")
 				})
 
@@ -175,7 +215,7 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 					executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
 						return errors.New("very bad error")
 					}
-					process = npminstall.NewRebuildBuildProcess(executable, summer, environment, scribe.NewLogger(buffer))
+					process = npminstall.NewRebuildBuildProcess(executable, summer, environment, clock, scribe.NewLogger(buffer))
 				})
 
 				it("fails", func() {
@@ -190,10 +230,10 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 	context("Run", func() {
 		context("launch is false", func() {
 			it("runs the npm rebuild command", func() {
-				Expect(process.Run(modulesDir, cacheDir, workingDir, "some-npmrc-path", false)).To(Succeed())
+				Expect(process.Run(modulesDir, cacheDir, workingDir, "some-npmrc-path", "", runtime.GOARCH, false)).To(Succeed())
 
 				Expect(executable.ExecuteCall.CallCount).To(Equal(4))
-				Expect(executions[0].Args).To(Equal([]string{"list"}))
+				Expect(executions[0].Args).To(Equal([]string{"ls", "--json", "--all"}))
 				Expect(executions[0].Dir).To(Equal(workingDir))
 				Expect(executions[0].Env).To(Equal(append(os.Environ(), "NPM_CONFIG_GLOBALCONFIG=some-npmrc-path")))
 
@@ -206,13 +246,14 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 					"      stderr output",
 				))
 
-				Expect(executions[2].Args).To(Equal([]string{"rebuild", "--nodedir="}))
+				Expect(executions[2].Args).To(Equal([]string{"rebuild", "some-module", "--nodedir=" + nodeHome}))
 				Expect(executions[2].Dir).To(Equal(workingDir))
-				Expect(executions[2].Env).To(Equal(append(os.Environ(), "NPM_CONFIG_GLOBALCONFIG=some-npmrc-path", "NPM_CONFIG_LOGLEVEL=some-val", "NODE_ENV=development")))
+				Expect(executions[2].Env).To(Equal(append(os.Environ(), "NPM_CONFIG_GLOBALCONFIG=some-npmrc-path", "NPM_CONFIG_LOGLEVEL=some-val", "NODE_ENV=development", "NPM_CONFIG_NODEDIR="+nodeHome)))
 				Expect(buffer.String()).To(ContainLines(
-					"    Running 'npm rebuild --nodedir='",
+					fmt.Sprintf("    Running 'npm rebuild some-module --nodedir=%s'", nodeHome),
 					"      stdout output",
 					"      stderr output",
+					"      Rebuilt some-module in 1s",
 				))
 
 				Expect(executions[3].Args).To(Equal([]string{"run-script", "postinstall", "--if-present"}))
@@ -232,10 +273,10 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 
 		context("launch is true", func() {
 			it("runs the npm rebuild command", func() {
-				Expect(process.Run(modulesDir, cacheDir, workingDir, "some-npmrc-path", true)).To(Succeed())
+				Expect(process.Run(modulesDir, cacheDir, workingDir, "some-npmrc-path", "", runtime.GOARCH, true)).To(Succeed())
 
 				Expect(executable.ExecuteCall.CallCount).To(Equal(4))
-				Expect(executions[0].Args).To(Equal([]string{"list"}))
+				Expect(executions[0].Args).To(Equal([]string{"ls", "--json", "--all"}))
 				Expect(executions[0].Dir).To(Equal(workingDir))
 				Expect(executions[0].Env).To(Equal(append(os.Environ(), "NPM_CONFIG_GLOBALCONFIG=some-npmrc-path")))
 
@@ -248,13 +289,14 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 					"      stderr output",
 				))
 
-				Expect(executions[2].Args).To(Equal([]string{"rebuild", "--nodedir="}))
+				Expect(executions[2].Args).To(Equal([]string{"rebuild", "some-module", "--nodedir=" + nodeHome}))
 				Expect(executions[2].Dir).To(Equal(workingDir))
-				Expect(executions[2].Env).To(Equal(append(os.Environ(), "NPM_CONFIG_GLOBALCONFIG=some-npmrc-path", "NPM_CONFIG_LOGLEVEL=some-val")))
+				Expect(executions[2].Env).To(Equal(append(os.Environ(), "NPM_CONFIG_GLOBALCONFIG=some-npmrc-path", "NPM_CONFIG_LOGLEVEL=some-val", "NPM_CONFIG_NODEDIR="+nodeHome)))
 				Expect(buffer.String()).To(ContainLines(
-					"    Running 'npm rebuild --nodedir='",
+					fmt.Sprintf("    Running 'npm rebuild some-module --nodedir=%s'", nodeHome),
 					"      stdout output",
 					"      stderr output",
+					"      Rebuilt some-module in 1s",
 				))
 
 				Expect(executions[3].Args).To(Equal([]string{"run-script", "postinstall", "--if-present"}))
@@ -274,10 +316,10 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 
 		context("when the package.json includes preinstall and postinstall scripts", func() {
 			it("runs the scripts before and after it runs the npm rebuild command", func() {
-				Expect(process.Run(modulesDir, cacheDir, workingDir, "some-npmrc-path", true)).To(Succeed())
+				Expect(process.Run(modulesDir, cacheDir, workingDir, "some-npmrc-path", "", runtime.GOARCH, true)).To(Succeed())
 
 				Expect(executable.ExecuteCall.CallCount).To(Equal(4))
-				Expect(executions[0].Args).To(Equal([]string{"list"}))
+				Expect(executions[0].Args).To(Equal([]string{"ls", "--json", "--all"}))
 				Expect(executions[0].Dir).To(Equal(workingDir))
 				Expect(executions[0].Env).To(Equal(append(os.Environ(), "NPM_CONFIG_GLOBALCONFIG=some-npmrc-path")))
 
@@ -290,13 +332,14 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 					"      stderr output",
 				))
 
-				Expect(executions[2].Args).To(Equal([]string{"rebuild", "--nodedir="}))
+				Expect(executions[2].Args).To(Equal([]string{"rebuild", "some-module", "--nodedir=" + nodeHome}))
 				Expect(executions[2].Dir).To(Equal(workingDir))
-				Expect(executions[2].Env).To(Equal(append(os.Environ(), "NPM_CONFIG_GLOBALCONFIG=some-npmrc-path", "NPM_CONFIG_LOGLEVEL=some-val")))
+				Expect(executions[2].Env).To(Equal(append(os.Environ(), "NPM_CONFIG_GLOBALCONFIG=some-npmrc-path", "NPM_CONFIG_LOGLEVEL=some-val", "NPM_CONFIG_NODEDIR="+nodeHome)))
 				Expect(buffer.String()).To(ContainLines(
-					"    Running 'npm rebuild --nodedir='",
+					fmt.Sprintf("    Running 'npm rebuild some-module --nodedir=%s'", nodeHome),
 					"      stdout output",
 					"      stderr output",
+					"      Rebuilt some-module in 1s",
 				))
 
 				Expect(executions[3].Args).To(Equal([]string{"run-script", "postinstall", "--if-present"}))
@@ -314,11 +357,405 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 			})
 		})
 
+		context("when several vendored packages require a rebuild", func() {
+			it.Before(func() {
+				for path, content := range map[string]string{
+					"@scope/native/binding.gyp":                   "",
+					"@scope/native/package.json":                  `{"name": "@scope/native"}`,
+					"gyp-module/package.json":                     `{"name": "gyp-module", "gypfile": true}`,
+					"pure-js/package.json":                        `{"name": "pure-js", "scripts": {"test": "true"}}`,
+					"pure-js/node_modules/nested/package.json":    `{"name": "nested", "scripts": {"postinstall": "node setup.js"}}`,
+					"unnamed-native/binding.gyp":                  "",
+					"unnamed-native/node_modules/.bin/executable": "",
+				} {
+					path = filepath.Join(workingDir, "node_modules", path)
+					Expect(os.MkdirAll(filepath.Dir(path), os.ModePerm)).To(Succeed())
+					Expect(os.WriteFile(path, []byte(content), 0644)).To(Succeed())
+				}
+			})
+
+			it("rebuilds only those packages by name", func() {
+				Expect(process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)).To(Succeed())
+
+				var rebuilds [][]string
+				for _, execution := range executions {
+					if execution.Args[0] == "rebuild" {
+						rebuilds = append(rebuilds, execution.Args)
+					}
+				}
+
+				Expect(rebuilds).To(Equal([][]string{
+					{"rebuild", "@scope/native", "--nodedir=" + nodeHome},
+					{"rebuild", "gyp-module", "--nodedir=" + nodeHome},
+					{"rebuild", "nested", "--nodedir=" + nodeHome},
+					{"rebuild", "some-module", "--nodedir=" + nodeHome},
+					{"rebuild", "unnamed-native", "--nodedir=" + nodeHome},
+				}))
+
+				Expect(buffer.String()).To(ContainSubstring("Rebuilt @scope/native in 1s"))
+				Expect(buffer.String()).To(ContainSubstring("Rebuilt unnamed-native in 1s"))
+			})
+		})
+
+		context("when no vendored packages require a rebuild", func() {
+			it.Before(func() {
+				Expect(os.Remove(filepath.Join(workingDir, "node_modules", "some-module", "package.json"))).To(Succeed())
+			})
+
+			it("skips npm rebuild", func() {
+				Expect(process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)).To(Succeed())
+
+				Expect(executable.ExecuteCall.CallCount).To(Equal(3))
+				for _, execution := range executions {
+					Expect(execution.Args[0]).NotTo(Equal("rebuild"))
+				}
+				Expect(buffer.String()).To(ContainLines("    Skipping 'npm rebuild': no packages with native code or install scripts found"))
+			})
+		})
+
+		context("when the native addon cache is available", func() {
+			var rebuilds func() []string
+
+			it.Before(func() {
+				Expect(os.WriteFile(filepath.Join(nodeHome, "include", "node", "node_version.h"), []byte("#define NODE_MAJOR_VERSION 20\n#define NODE_MODULE_VERSION 115\n"), 0600)).To(Succeed())
+
+				Expect(os.MkdirAll(filepath.Join(workingDir, "node_modules", "gyp-module"), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(workingDir, "node_modules", "gyp-module", "package.json"), []byte(`{"name": "gyp-module", "version": "1.0.0", "gypfile": true}`), 0644)).To(Succeed())
+
+				executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+					executions = append(executions, execution)
+					if execution.Args[0] == "rebuild" {
+						release := filepath.Join(workingDir, "node_modules", execution.Args[1], "build", "Release")
+						Expect(os.MkdirAll(release, os.ModePerm)).To(Succeed())
+						Expect(os.WriteFile(filepath.Join(release, "addon.node"), []byte("compiled"), 0644)).To(Succeed())
+					}
+					return nil
+				}
+
+				rebuilds = func() []string {
+					var names []string
+					for _, execution := range executions {
+						if execution.Args[0] == "rebuild" {
+							names = append(names, execution.Args[1])
+						}
+					}
+					return names
+				}
+
+				Expect(process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)).To(Succeed())
+			})
+
+			it("stores the output of packages built only by node-gyp", func() {
+				Expect(rebuilds()).To(Equal([]string{"gyp-module", "some-module"}))
+				Expect(buffer.String()).To(ContainLines("    Native addon cache miss: gyp-module@1.0.0"))
+				Expect(buffer.String()).NotTo(ContainSubstring("Native addon cache miss: some-module"))
+
+				entries, err := filepath.Glob(filepath.Join(cacheDir, "native-addons", "*", "Release", "addon.node"))
+				Expect(err).NotTo(HaveOccurred())
+				Expect(entries).To(HaveLen(1))
+			})
+
+			context("when the same packages are rebuilt again", func() {
+				it.Before(func() {
+					Expect(os.Remove(filepath.Join(workingDir, "node_modules"))).To(Succeed())
+					Expect(os.Rename(filepath.Join(modulesDir, "node_modules"), filepath.Join(workingDir, "node_modules"))).To(Succeed())
+					Expect(os.RemoveAll(filepath.Join(workingDir, "node_modules", "gyp-module", "build"))).To(Succeed())
+
+					executions = nil
+					buffer.Reset()
+				})
+
+				it("restores them from the cache and still runs the install scripts of other packages", func() {
+					Expect(process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)).To(Succeed())
+
+					Expect(rebuilds()).To(Equal([]string{"some-module"}))
+					Expect(buffer.String()).To(ContainLines("    Native addon cache hit: gyp-module@1.0.0"))
+
+					content, err := os.ReadFile(filepath.Join(modulesDir, "node_modules", "gyp-module", "build", "Release", "addon.node"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(content)).To(Equal("compiled"))
+				})
+			})
+
+			context("when the package is no longer installed", func() {
+				it.Before(func() {
+					Expect(os.Remove(filepath.Join(workingDir, "node_modules"))).To(Succeed())
+					Expect(os.Rename(filepath.Join(modulesDir, "node_modules"), filepath.Join(workingDir, "node_modules"))).To(Succeed())
+					Expect(os.RemoveAll(filepath.Join(workingDir, "node_modules", "gyp-module"))).To(Succeed())
+				})
+
+				it("removes its entry from the cache", func() {
+					Expect(process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)).To(Succeed())
+
+					entries, err := os.ReadDir(filepath.Join(cacheDir, "native-addons"))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(entries).To(BeEmpty())
+				})
+			})
+		})
+
+		context("when vendored node_modules contain binaries built for another platform", func() {
+			var nativeBinary, foreignBinary, nativeArch, foreignArch string
+
+			copyFixture := func(fixture, path string, mode os.FileMode) {
+				content, err := os.ReadFile(filepath.Join("testdata", "binaries", fixture))
+				Expect(err).NotTo(HaveOccurred())
+
+				path = filepath.Join(workingDir, "node_modules", path)
+				Expect(os.MkdirAll(filepath.Dir(path), os.ModePerm)).To(Succeed())
+				Expect(os.WriteFile(path, content, mode)).To(Succeed())
+			}
+
+			it.Before(func() {
+				nativeBinary, foreignBinary = "linux-amd64.node", "linux-arm64.node"
+				nativeArch, foreignArch = "x64", "arm64"
+				if runtime.GOARCH == "arm64" {
+					nativeBinary, foreignBinary = foreignBinary, nativeBinary
+					nativeArch, foreignArch = foreignArch, nativeArch
+				}
+
+				copyFixture(nativeBinary, "native-addon/build/Release/addon.node", 0644)
+				copyFixture("darwin-arm64.node", "mac-addon/build/Release/addon.node", 0644)
+				copyFixture("windows-amd64.exe", "@scope/windows-addon/build/Debug/addon.node", 0644)
+				copyFixture(foreignBinary, "native-addon/node_modules/prebuilt/prebuilds/linux-"+nativeArch+"/prebuilt.node", 0644)
+
+				copyFixture("darwin-arm64.node", "multi-platform/prebuilds/darwin-x64+arm64/node.napi.node", 0644)
+				copyFixture("windows-amd64.exe", "multi-platform/prebuilds/win32-x64/node.napi.node", 0644)
+				copyFixture(foreignBinary, "multi-platform/prebuilds/linux-"+foreignArch+"/node.napi.node", 0644)
+				copyFixture(nativeBinary, "multi-platform/prebuilds/linux-"+nativeArch+"/node.napi.node", 0644)
+
+				copyFixture("darwin-arm64.node", "7zip-bin/mac/arm64/7za", 0755)
+				copyFixture("windows-amd64.exe", "7zip-bin/win/x64/7za.exe", 0755)
+				copyFixture(foreignBinary, "7zip-bin/linux/"+foreignArch+"/7za", 0755)
+			})
+
+			context("when npm rebuild replaces them", func() {
+				it.Before(func() {
+					executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+						executions = append(executions, execution)
+						if execution.Args[0] == "rebuild" {
+							copyFixture(nativeBinary, "mac-addon/build/Release/addon.node", 0644)
+							copyFixture(nativeBinary, "@scope/windows-addon/build/Debug/addon.node", 0644)
+							copyFixture(nativeBinary, "native-addon/node_modules/prebuilt/prebuilds/linux-"+nativeArch+"/prebuilt.node", 0644)
+						}
+						return nil
+					}
+				})
+
+				it("forces a rebuild of the packages that contain them", func() {
+					Expect(process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)).To(Succeed())
+
+					var rebuilds [][]string
+					for _, execution := range executions {
+						if execution.Args[0] == "rebuild" {
+							rebuilds = append(rebuilds, execution.Args)
+						}
+					}
+
+					Expect(rebuilds).To(Equal([][]string{
+						{"rebuild", "@scope/windows-addon", "--nodedir=" + nodeHome},
+						{"rebuild", "mac-addon", "--nodedir=" + nodeHome},
+						{"rebuild", "prebuilt", "--nodedir=" + nodeHome},
+						{"rebuild", "some-module", "--nodedir=" + nodeHome},
+					}))
+
+					Expect(buffer.String()).To(ContainLines(
+						"    Vendored node_modules contain binaries built for another platform, forcing a rebuild:",
+						"      @scope/windows-addon/build/Debug/addon.node (PE amd64)",
+						"      mac-addon/build/Release/addon.node (Mach-O arm64)",
+						fmt.Sprintf("      native-addon/node_modules/prebuilt/prebuilds/linux-%s/prebuilt.node (ELF %s)", nativeArch, strings.TrimSuffix(strings.TrimPrefix(foreignBinary, "linux-"), ".node")),
+					))
+				})
+			})
+
+			context("when npm rebuild does not replace them", func() {
+				it("returns an error listing the offending files", func() {
+					err := process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)
+					Expect(err).To(MatchError(ContainSubstring("vendored node_modules contain binaries built for another platform")))
+					Expect(err).To(MatchError(ContainSubstring("  @scope/windows-addon/build/Debug/addon.node (PE amd64)\n  mac-addon/build/Release/addon.node (Mach-O arm64)\n  native-addon/node_modules/prebuilt/prebuilds/linux-" + nativeArch + "/prebuilt.node (ELF ")))
+					Expect(err).NotTo(MatchError(ContainSubstring("native-addon/build/Release/addon.node")))
+				})
+			})
+
+			context("when the binaries are outside of the build directories", func() {
+				it.Before(func() {
+					copyFixture("darwin-arm64.node", "root-addon/addon.node", 0644)
+					copyFixture("darwin-arm64.node", "some-tool/bin/some-tool", 0755)
+					copyFixture("darwin-arm64.node", "some-tool/lib/data.bin", 0644)
+				})
+
+				it("reports the addons and executables", func() {
+					err := process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)
+					Expect(err).To(MatchError(ContainSubstring("  root-addon/addon.node (Mach-O arm64)\n  some-tool/bin/some-tool (Mach-O arm64)")))
+					Expect(err).NotTo(MatchError(ContainSubstring("some-tool/lib/data.bin")))
+
+					var rebuilds [][]string
+					for _, execution := range executions {
+						if execution.Args[0] == "rebuild" {
+							rebuilds = append(rebuilds, execution.Args)
+						}
+					}
+					Expect(rebuilds).To(ContainElements(
+						[]string{"rebuild", "root-addon", "--nodedir=" + nodeHome},
+						[]string{"rebuild", "some-tool", "--nodedir=" + nodeHome},
+					))
+				})
+			})
+
+			context("when the target architecture differs from the build container", func() {
+				it("verifies the binaries against the target architecture", func() {
+					targetArch := "arm64"
+					if runtime.GOARCH == "arm64" {
+						targetArch = "amd64"
+					}
+
+					err := process.Run(modulesDir, cacheDir, workingDir, "", "", targetArch, true)
+					Expect(err).To(MatchError(ContainSubstring("  native-addon/build/Release/addon.node (ELF ")))
+					Expect(err).To(MatchError(ContainSubstring("vendor dependencies built for linux/" + targetArch)))
+				})
+			})
+
+			context("when packages ship binaries for several platforms", func() {
+				it.Before(func() {
+					Expect(os.RemoveAll(filepath.Join(workingDir, "node_modules", "mac-addon"))).To(Succeed())
+					Expect(os.RemoveAll(filepath.Join(workingDir, "node_modules", "@scope"))).To(Succeed())
+					Expect(os.RemoveAll(filepath.Join(workingDir, "node_modules", "native-addon", "node_modules"))).To(Succeed())
+				})
+
+				it("ignores the binaries node does not load on the target platform", func() {
+					Expect(process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)).To(Succeed())
+
+					var rebuilds [][]string
+					for _, execution := range executions {
+						if execution.Args[0] == "rebuild" {
+							rebuilds = append(rebuilds, execution.Args)
+						}
+					}
+
+					Expect(rebuilds).To(Equal([][]string{
+						{"rebuild", "some-module", "--nodedir=" + nodeHome},
+					}))
+					Expect(buffer.String()).NotTo(ContainSubstring("built for another platform"))
+				})
+			})
+		})
+
+		context("when a node-headers tarball is supplied", func() {
+			var tarball string
+
+			it.Before(func() {
+				tarball = filepath.Join(t.TempDir(), "node-headers.tar.gz")
+				Expect(os.WriteFile(tarball, []byte("headers"), 0600)).To(Succeed())
+				Expect(os.WriteFile(filepath.Join(workingDir, "node_modules", "some-module", "binding.gyp"), nil, 0644)).To(Succeed())
+			})
+
+			it("points node-gyp at the tarball instead of NODE_HOME", func() {
+				Expect(process.Run(modulesDir, cacheDir, workingDir, "", tarball, runtime.GOARCH, true)).To(Succeed())
+
+				Expect(executions[2].Args).To(Equal([]string{"rebuild", "some-module"}))
+				Expect(executions[2].Env).To(ContainElement("NPM_CONFIG_TARBALL=" + tarball))
+				Expect(executions[2].Env).NotTo(ContainElement(HavePrefix("NPM_CONFIG_NODEDIR=")))
+			})
+		})
+
+		context("when no package is compiled by node-gyp", func() {
+			it.Before(func() {
+				Expect(os.RemoveAll(filepath.Join(nodeHome, "include"))).To(Succeed())
+			})
+
+			it("rebuilds the packages without requiring the node-gyp headers", func() {
+				Expect(process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)).To(Succeed())
+
+				Expect(executions[2].Args).To(Equal([]string{"rebuild", "some-module", "--nodedir=" + nodeHome}))
+			})
+		})
+
 		context("failure cases", func() {
-			context("when npm list fails", func() {
-				it("returns an error", func() {
+			context("when npm ls reports unmet dependencies", func() {
+				it.Before(func() {
 					executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
-						if strings.Contains(strings.Join(execution.Args, " "), "list") {
+						if strings.Join(execution.Args, " ") == "ls --json --all" {
+							if _, err := fmt.Fprintln(execution.Stdout, `{
+								"name": "some-app",
+								"version": "1.0.0",
+								"dependencies": {
+									"express": {
+										"required": "^5.2.1",
+										"missing": true
+									},
+									"logfmt": {
+										"version": "0.1.0",
+										"invalid": "\"^1.3.2\" from the root project",
+										"dependencies": {
+											"split": {
+												"required": {"version": "0.2.x"},
+												"missing": true
+											}
+										}
+									},
+									"left-pad": {
+										"version": "1.3.0",
+										"extraneous": true
+									},
+									"debug": {
+										"version": "4.3.4"
+									}
+								}
+							}`); err != nil {
+								return err
+							}
+							if _, err := fmt.Fprintln(execution.Stderr, "npm ERR! code ELSPROBLEMS"); err != nil {
+								return err
+							}
+							return errors.New("exit status 1")
+						}
+
+						return nil
+					}
+				})
+
+				it("returns a structured report", func() {
+					err := process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)
+					Expect(buffer.String()).To(ContainLines(
+						"      npm ERR! code ELSPROBLEMS",
+						"    Vendored node_modules have unmet dependencies:",
+						"      missing: express@^5.2.1, required by some-app@1.0.0",
+						"      extraneous: left-pad@1.3.0",
+						`      invalid: logfmt@0.1.0 ("^1.3.2" from the root project), required by some-app@1.0.0`,
+						"      missing: split@0.2.x, required by logfmt@0.1.0",
+					))
+					Expect(err).To(MatchError(strings.Join([]string{
+						"vendored node_modules have unmet dependencies:",
+						"  missing: express@^5.2.1, required by some-app@1.0.0",
+						"  extraneous: left-pad@1.3.0",
+						`  invalid: logfmt@0.1.0 ("^1.3.2" from the root project), required by some-app@1.0.0`,
+						"  missing: split@0.2.x, required by logfmt@0.1.0",
+					}, "\n")))
+				})
+			})
+
+			context("when the committed lockfile is below BP_NPM_LOCKFILE_MIN_VERSION", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(filepath.Join(workingDir, "package-lock.json"), []byte(`{"lockfileVersion": 1}`), 0600)).To(Succeed())
+					environment.LookupCall.Stub = func(key string) (string, bool) {
+						if key == "BP_NPM_LOCKFILE_MIN_VERSION" {
+							return "3", true
+						}
+						return "", false
+					}
+				})
+
+				it("returns an error explaining the policy before running npm", func() {
+					err := process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)
+					Expect(err).To(MatchError(ContainSubstring("package-lock.json has lockfileVersion 1 but BP_NPM_LOCKFILE_MIN_VERSION requires at least 3")))
+					Expect(executable.ExecuteCall.CallCount).To(Equal(0))
+				})
+			})
+
+			context("when npm ls fails without a parsable report", func() {
+				it.Before(func() {
+					executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+						if strings.Join(execution.Args, " ") == "ls --json --all" {
 							if _, err := fmt.Fprintln(execution.Stdout, "stdout output"); err != nil {
 								return err
 							}
@@ -330,13 +767,14 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 
 						return nil
 					}
+				})
 
-					err := process.Run(modulesDir, cacheDir, workingDir, "", true)
+				it("returns an error", func() {
+					err := process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)
 					Expect(buffer.String()).To(ContainLines(
-						"      stdout output",
 						"      stderr output",
 					))
-					Expect(err).To(MatchError("vendored node_modules have unmet dependencies: npm list failed: exit status 1"))
+					Expect(err).To(MatchError("vendored node_modules have unmet dependencies: npm ls failed: exit status 1"))
 				})
 			})
 
@@ -350,11 +788,40 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 				})
 
 				it("returns an error", func() {
-					err := process.Run(modulesDir, cacheDir, workingDir, "", true)
+					err := process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)
 					Expect(err).To(MatchError(ContainSubstring("permission denied")))
 				})
 			})
 
+			context("when the node-gyp headers are missing from NODE_HOME", func() {
+				it.Before(func() {
+					Expect(os.RemoveAll(filepath.Join(nodeHome, "include"))).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(workingDir, "node_modules", "some-module", "binding.gyp"), nil, 0644)).To(Succeed())
+				})
+
+				it("fails before running npm rebuild", func() {
+					err := process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)
+					Expect(err).To(MatchError(ContainSubstring(fmt.Sprintf("node-gyp headers not found in NODE_HOME (%q)", nodeHome))))
+					Expect(err).To(MatchError(ContainSubstring("service binding of type 'node-headers'")))
+
+					for _, execution := range executions {
+						Expect(execution.Args[0]).NotTo(Equal("rebuild"))
+					}
+				})
+			})
+
+			context("when the node-headers tarball does not exist", func() {
+				it.Before(func() {
+					Expect(os.WriteFile(filepath.Join(workingDir, "node_modules", "some-module", "binding.gyp"), nil, 0644)).To(Succeed())
+				})
+
+				it("returns an error", func() {
+					err := process.Run(modulesDir, cacheDir, workingDir, "", filepath.Join(nodeHome, "missing.tar.gz"), runtime.GOARCH, true)
+					Expect(err).To(MatchError(ContainSubstring("node-gyp headers tarball")))
+					Expect(err).To(MatchError(ContainSubstring("does not exist")))
+				})
+			})
+
 			context("when preinstall scripts fail", func() {
 				it.Before(func() {
 					executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
@@ -373,7 +840,7 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 				})
 
 				it("returns an error", func() {
-					err := process.Run(modulesDir, cacheDir, workingDir, "", true)
+					err := process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)
 					Expect(buffer.String()).To(ContainLines(
 						"    Running 'npm run-script preinstall --if-present'",
 						"      pre-install on stdout",
@@ -401,9 +868,9 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 				})
 
 				it("returns an error", func() {
-					err := process.Run(modulesDir, cacheDir, workingDir, "", true)
+					err := process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)
 					Expect(buffer.String()).To(ContainLines(
-						"    Running 'npm rebuild --nodedir='",
+						fmt.Sprintf("    Running 'npm rebuild some-module --nodedir=%s'", nodeHome),
 						"      rebuild error on stdout",
 						"      rebuild error on stderr",
 					))
@@ -411,6 +878,29 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 				})
 			})
 
+			context("when node-gyp cannot find Python", func() {
+				it.Before(func() {
+					executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
+						if execution.Args[0] == "rebuild" {
+							fmt.Fprintln(execution.Stderr, "gyp ERR! find Python")
+							fmt.Fprintln(execution.Stderr, "gyp ERR! find Python Python is not set from command line or npm configuration")
+							return errors.New("exit status 1")
+						}
+
+						return nil
+					}
+				})
+
+				it("suggests making Python available", func() {
+					err := process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)
+					Expect(err).To(MatchError("npm rebuild failed: exit status 1: node-gyp could not find Python to compile a native module (gyp ERR!): set BP_NPM_INCLUDE_BUILD_PYTHON=true to make Python available during the build"))
+					Expect(buffer.String()).To(ContainLines(
+						"    npm failed with gyp ERR!: node-gyp could not find Python to compile a native module",
+						"      Hint: set BP_NPM_INCLUDE_BUILD_PYTHON=true to make Python available during the build",
+					))
+				})
+			})
+
 			context("when postinstall scripts fail", func() {
 				it.Before(func() {
 					executable.ExecuteCall.Stub = func(execution pexec.Execution) error {
@@ -429,7 +919,7 @@ func testRebuildBuildProcess(t *testing.T, context spec.G, it spec.S) {
 				})
 
 				it("returns an error", func() {
-					err := process.Run(modulesDir, cacheDir, workingDir, "", true)
+					err := process.Run(modulesDir, cacheDir, workingDir, "", "", runtime.GOARCH, true)
 					Expect(buffer.String()).To(ContainLines(
 						"    Running 'npm run-script postinstall --if-present'",
 						"      postinstall on stdout",
@@ -0,0 +1,224 @@
+package npminstall
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/paketo-buildpacks/packit/v2/fs"
+)
+
+// rebuildTarget is a package that needs to be rebuilt along with every
+// location in node_modules where it is installed.
+type rebuildTarget struct {
+	Name  string
+	Paths []string
+
+	// Gyp is set when the package is compiled by node-gyp, i.e. it ships a
+	// binding.gyp or declares "gypfile": true.
+	Gyp bool
+
+	// Scripts is set when the package defines install scripts of its own
+	// that do more than build it with node-gyp or download a prebuilt
+	// binary in its place.
+	Scripts bool
+
+	// Force skips the native addon cache for the package.
+	Force bool
+}
+
+// cacheable reports whether the build/Release output of the target is all
+// that rebuilding it produces, so that it can be restored from the native
+// addon cache instead: the package is compiled by node-gyp and npm runs no
+// other install scripts for it.
+func (t rebuildTarget) cacheable() bool {
+	return t.Gyp && !t.Scripts
+}
+
+// compilesNativeCode reports whether any of the targets is compiled by
+// node-gyp and therefore needs the Node.js headers.
+func compilesNativeCode(targets []rebuildTarget) bool {
+	for _, target := range targets {
+		if target.Gyp {
+			return true
+		}
+	}
+
+	return false
+}
+
+// findRebuildTargets walks the given node_modules directory and returns the
+// packages that need to be rebuilt, i.e. packages that ship a binding.gyp,
+// declare "gypfile": true or define install scripts.
+func findRebuildTargets(nodeModulesPath string) ([]rebuildTarget, error) {
+	targets := map[string]*rebuildTarget{}
+	err := collectRebuildTargets(nodeModulesPath, "", targets)
+	if err != nil {
+		return nil, err
+	}
+
+	var names []string
+	for name := range targets {
+		names = append(names, name)
+	}
+
+	sort.Strings(names)
+
+	var result []rebuildTarget
+	for _, name := range names {
+		result = append(result, *targets[name])
+	}
+
+	return result, nil
+}
+
+// addRebuildTarget adds the package installed at the given path to the list
+// of targets, forcing it to be rebuilt from source.
+func addRebuildTarget(targets []rebuildTarget, name, path string) []rebuildTarget {
+	for i, target := range targets {
+		if target.Name == name {
+			targets[i].Force = true
+			for _, p := range target.Paths {
+				if p == path {
+					return targets
+				}
+			}
+			targets[i].Paths = append(targets[i].Paths, path)
+			return targets
+		}
+	}
+
+	targets = append(targets, rebuildTarget{Name: name, Paths: []string{path}, Force: true})
+	sort.Slice(targets, func(i, j int) bool {
+		return targets[i].Name < targets[j].Name
+	})
+
+	return targets
+}
+
+func collectRebuildTargets(nodeModulesPath, scope string, targets map[string]*rebuildTarget) error {
+	entries, err := os.ReadDir(nodeModulesPath)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		return fmt.Errorf("failed to read node_modules directory: %w", err)
+	}
+
+	for _, entry := range entries {
+		if !entry.IsDir() || strings.HasPrefix(entry.Name(), ".") {
+			continue
+		}
+
+		if scope == "" && strings.HasPrefix(entry.Name(), "@") {
+			err = collectRebuildTargets(filepath.Join(nodeModulesPath, entry.Name()), entry.Name(), targets)
+			if err != nil {
+				return err
+			}
+			continue
+		}
+
+		packagePath := filepath.Join(nodeModulesPath, entry.Name())
+		name, gyp, scripts, err := requiresRebuild(packagePath)
+		if err != nil {
+			return err
+		}
+
+		if gyp || scripts {
+			if name == "" {
+				name = path.Join(scope, entry.Name())
+			}
+
+			target, ok := targets[name]
+			if !ok {
+				target = &rebuildTarget{Name: name}
+				targets[name] = target
+			}
+
+			target.Paths = append(target.Paths, packagePath)
+			target.Gyp = target.Gyp || gyp
+			target.Scripts = target.Scripts || scripts
+		}
+
+		err = collectRebuildTargets(filepath.Join(packagePath, "node_modules"), "", targets)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// requiresRebuild returns the name of the package installed at the given
+// path and whether it is compiled by node-gyp or defines install scripts.
+func requiresRebuild(packagePath string) (string, bool, bool, error) {
+	var pkg struct {
+		Name    string            `json:"name"`
+		Gypfile bool              `json:"gypfile"`
+		Scripts map[string]string `json:"scripts"`
+	}
+
+	content, err := os.ReadFile(filepath.Join(packagePath, "package.json"))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return "", false, false, fmt.Errorf("failed to read package.json: %w", err)
+	}
+
+	if err == nil {
+		err = json.Unmarshal(content, &pkg)
+		if err != nil {
+			return "", false, false, fmt.Errorf("failed to parse %s: %w", filepath.Join(packagePath, "package.json"), err)
+		}
+	}
+
+	gyp := pkg.Gypfile
+	if !gyp {
+		gyp, err = fs.Exists(filepath.Join(packagePath, "binding.gyp"))
+		if err != nil {
+			return "", false, false, err
+		}
+	}
+
+	var scripts bool
+	for _, script := range []string{"preinstall", "install", "postinstall"} {
+		if command, ok := pkg.Scripts[script]; ok && !(gyp && buildsBinding(command)) {
+			scripts = true
+		}
+	}
+
+	return pkg.Name, gyp, scripts, nil
+}
+
+// buildsBinding reports whether the given install script only produces the
+// build/Release output of the package, i.e. it runs 'node-gyp rebuild' or
+// 'prebuild-install', possibly falling back from one to the other with "||".
+func buildsBinding(script string) bool {
+	for _, alternative := range strings.Split(script, "||") {
+		fields := strings.Fields(alternative)
+		if len(fields) == 0 {
+			return false
+		}
+
+		for _, field := range fields[1:] {
+			if strings.ContainsAny(field, "&;|<>$`") {
+				return false
+			}
+		}
+
+		switch fields[0] {
+		case "node-gyp":
+			if len(fields) < 2 || fields[1] != "rebuild" {
+				return false
+			}
+		case "prebuild-install":
+		default:
+			return false
+		}
+	}
+
+	return true
+}
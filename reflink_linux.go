@@ -0,0 +1,42 @@
+package npminstall
+
+import (
+	"errors"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// reflinkFile clones the source file into the destination with the FICLONE
+// ioctl, sharing the underlying extents until either file is modified.
+func reflinkFile(source, destination string) (err error) {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, sourceFile.Close())
+	}()
+
+	info, err := sourceFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	destinationFile, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+
+	err = unix.IoctlFileClone(int(destinationFile.Fd()), int(sourceFile.Fd()))
+	if err != nil {
+		err = errors.Join(err, destinationFile.Close(), os.Remove(destination))
+		if errors.Is(err, unix.EOPNOTSUPP) || errors.Is(err, unix.EXDEV) || errors.Is(err, unix.EINVAL) || errors.Is(err, unix.ENOTTY) {
+			return fmt.Errorf("%w: %s", errCopyMethodUnsupported, err)
+		}
+		return err
+	}
+
+	return errors.Join(destinationFile.Chmod(info.Mode()), destinationFile.Close())
+}
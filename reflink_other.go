@@ -0,0 +1,8 @@
+//go:build !linux
+
+package npminstall
+
+// reflinkFile is only supported on Linux.
+func reflinkFile(source, destination string) error {
+	return errCopyMethodUnsupported
+}
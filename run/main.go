@@ -4,6 +4,8 @@ import (
 	"log"
 	"os"
 	"path/filepath"
+	"syscall"
+	"time"
 
 	npminstall "github.com/paketo-buildpacks/npm-install"
 
@@ -11,7 +13,6 @@ import (
 	"github.com/paketo-buildpacks/packit/v2/chronos"
 	"github.com/paketo-buildpacks/packit/v2/draft"
 	"github.com/paketo-buildpacks/packit/v2/fs"
-	"github.com/paketo-buildpacks/packit/v2/pexec"
 	"github.com/paketo-buildpacks/packit/v2/sbom"
 	"github.com/paketo-buildpacks/packit/v2/scribe"
 	"github.com/paketo-buildpacks/packit/v2/servicebindings"
@@ -30,29 +31,43 @@ func main() {
 	}
 
 	logLevel, _ := environment.Lookup("BP_LOG_LEVEL")
-	globalConfigPath, _ := environment.Lookup("NPM_CONFIG_GLOBALCONFIG")
 
 	emitter := scribe.NewEmitter(os.Stdout).WithLevel(logLevel)
 	logger := scribe.NewLogger(os.Stdout).WithLevel(logLevel)
 
-	npm := pexec.NewExecutable("npm")
+	npmTimeout, err := npminstall.LookupNpmTimeout(environment)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	interrupt := npminstall.NewInterrupt()
+	interrupt.Notify(syscall.SIGTERM, syscall.SIGINT)
+
+	fingerprintMode, err := npminstall.LookupFingerprintMode(environment)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	npm := npminstall.NewNpmExecutable("npm", npmTimeout).WithInterrupt(interrupt)
 	checksumCalculator := fs.NewChecksumCalculator()
+	fingerprinter := npminstall.NewFingerprinter(fingerprintMode)
 	linker := npminstall.NewLinker(os.TempDir())
 
 	packit.Run(
-		npminstall.Detect(),
+		npminstall.Detect(environment),
 		npminstall.Build(
 			draft.NewPlanner(),
 			npminstall.NewPackageManagerConfigurationManager(
 				servicebindings.NewResolver(),
 				emitter,
-				globalConfigPath,
+				"",
 			),
 			npminstall.NewBuildProcessResolver(
 				logger,
-				npminstall.NewRebuildBuildProcess(npm, checksumCalculator, environment, logger),
-				npminstall.NewInstallBuildProcess(npm, environment, logger),
-				npminstall.NewCIBuildProcess(npm, checksumCalculator, environment, logger),
+				npminstall.NewRebuildBuildProcess(npm, fingerprinter, environment, chronos.DefaultClock, logger),
+				npminstall.NewInstallBuildProcess(npm, checksumCalculator, environment, time.Second, logger),
+				npminstall.NewCIBuildProcess(npm, checksumCalculator, environment, time.Second, logger),
+				environment,
 			),
 			npminstall.NewPruneBuildProcess(
 				npm,
@@ -64,7 +79,11 @@ func main() {
 			SBOMGenerator{},
 			linker,
 			environment,
+			checksumCalculator,
+			fingerprinter,
 			npminstall.NewLinkedModuleResolver(linker),
+			npminstall.NewNpmEngineResolver(npm, environment, logger),
+			interrupt,
 		),
 	)
 }
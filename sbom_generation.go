@@ -0,0 +1,59 @@
+package npminstall
+
+import (
+	"time"
+
+	"github.com/paketo-buildpacks/packit/v2/chronos"
+	"github.com/paketo-buildpacks/packit/v2/sbom"
+	"github.com/paketo-buildpacks/packit/v2/scribe"
+)
+
+// pendingSBOM is an SBOM that is generated in the background while the build
+// continues. Its log output is only written once it is waited for, so that
+// the build log does not depend on how long the generation takes.
+type pendingSBOM struct {
+	layerPath string
+	done      chan struct{}
+	duration  time.Duration
+	content   sbom.SBOM
+	err       error
+}
+
+// generateSBOMInBackground starts generating the SBOM for the contents of the
+// layer at layerPath. The layer must not change until the SBOM is waited for.
+func generateSBOMInBackground(generator SBOMGenerator, clock chronos.Clock, layerPath string) *pendingSBOM {
+	pending := &pendingSBOM{
+		layerPath: layerPath,
+		done:      make(chan struct{}),
+	}
+
+	go func() {
+		defer close(pending.done)
+
+		pending.duration, pending.err = clock.Measure(func() error {
+			var err error
+			pending.content, err = generator.Generate(layerPath)
+			return err
+		})
+	}()
+
+	return pending
+}
+
+// Wait blocks until the SBOM is generated, logs its generation and returns it
+// in the given formats.
+func (p *pendingSBOM) Wait(logger scribe.Emitter, formats []string) (sbom.Formatter, error) {
+	<-p.done
+
+	logger.GeneratingSBOM(p.layerPath)
+	if p.err != nil {
+		return sbom.Formatter{}, p.err
+	}
+
+	logger.Action("Completed in %s", p.duration.Round(time.Millisecond))
+	logger.Break()
+
+	logger.FormattingSBOM(formats...)
+
+	return p.content.InFormats(formats...)
+}
@@ -0,0 +1,171 @@
+package npminstall
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"syscall"
+)
+
+// CopyMethod is a way of copying a single regular file.
+type CopyMethod string
+
+const (
+	// CopyMethodReflink clones the file on filesystems that support
+	// copy-on-write, e.g. btrfs or XFS.
+	CopyMethodReflink CopyMethod = "reflink"
+
+	// CopyMethodHardlink links the file into the destination. Files are shared
+	// with the source, so only trees whose files are replaced rather than
+	// modified in place may be copied this way.
+	CopyMethodHardlink CopyMethod = "hardlink"
+
+	// CopyMethodCopy copies the contents of the file.
+	CopyMethodCopy CopyMethod = "copy"
+)
+
+// errCopyMethodUnsupported is returned by a copy method that cannot be used
+// for the given source and destination, e.g. because they are on different
+// filesystems.
+var errCopyMethodUnsupported = errors.New("copy method unsupported")
+
+// TreeCopier copies directory trees, trying each of its copy methods in turn
+// for every file. A method that turns out to be unsupported is not tried again
+// for the remaining files of the tree.
+type TreeCopier struct {
+	methods []CopyMethod
+}
+
+// NewTreeCopier returns a copier that uses the given methods in order. Without
+// methods, files are cloned where the filesystem supports it and copied
+// otherwise, so that the copy never shares files with the source. Hardlinking
+// has to be requested explicitly.
+func NewTreeCopier(methods ...CopyMethod) TreeCopier {
+	if len(methods) == 0 {
+		methods = []CopyMethod{CopyMethodReflink, CopyMethodCopy}
+	}
+
+	return TreeCopier{
+		methods: methods,
+	}
+}
+
+// Copy copies the source file or directory to the destination, which is
+// removed first if it exists. Symlinks are copied as they are.
+func (c TreeCopier) Copy(source, destination string) error {
+	err := os.RemoveAll(destination)
+	if err != nil {
+		return fmt.Errorf("failed to copy: could not remove destination: %w", err)
+	}
+
+	unsupported := make([]bool, len(c.methods))
+
+	return filepath.WalkDir(source, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destination, rel)
+
+		switch {
+		case entry.IsDir():
+			info, err := entry.Info()
+			if err != nil {
+				return err
+			}
+
+			return os.MkdirAll(target, info.Mode().Perm())
+
+		case entry.Type()&fs.ModeSymlink != 0:
+			link, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+
+			return os.Symlink(link, target)
+
+		case entry.Type().IsRegular():
+			return c.copyFile(path, target, unsupported)
+		}
+
+		return nil
+	})
+}
+
+func (c TreeCopier) copyFile(source, destination string, unsupported []bool) error {
+	for i, method := range c.methods {
+		if unsupported[i] {
+			continue
+		}
+
+		var err error
+		switch method {
+		case CopyMethodReflink:
+			err = reflinkFile(source, destination)
+		case CopyMethodHardlink:
+			err = hardlinkFile(source, destination)
+		case CopyMethodCopy:
+			err = copyFileContents(source, destination)
+		default:
+			return fmt.Errorf("unknown copy method %q", method)
+		}
+
+		if errors.Is(err, errCopyMethodUnsupported) {
+			unsupported[i] = true
+			continue
+		}
+
+		return err
+	}
+
+	return fmt.Errorf("failed to copy %s: no supported copy method", source)
+}
+
+func hardlinkFile(source, destination string) error {
+	err := os.Link(source, destination)
+	if err != nil {
+		if errors.Is(err, syscall.EXDEV) || errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.EMLINK) || errors.Is(err, syscall.ENOTSUP) {
+			return fmt.Errorf("%w: %s", errCopyMethodUnsupported, err)
+		}
+		return err
+	}
+
+	return nil
+}
+
+func copyFileContents(source, destination string) (err error) {
+	sourceFile, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, sourceFile.Close())
+	}()
+
+	info, err := sourceFile.Stat()
+	if err != nil {
+		return err
+	}
+
+	destinationFile, err := os.OpenFile(destination, os.O_WRONLY|os.O_CREATE|os.O_EXCL, info.Mode().Perm())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		err = errors.Join(err, destinationFile.Close())
+	}()
+
+	_, err = io.Copy(destinationFile, sourceFile)
+	if err != nil {
+		return err
+	}
+
+	return destinationFile.Chmod(info.Mode())
+}
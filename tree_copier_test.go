@@ -0,0 +1,161 @@
+package npminstall_test
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	npminstall "github.com/paketo-buildpacks/npm-install"
+	"github.com/sclevine/spec"
+
+	. "github.com/onsi/gomega"
+)
+
+func testTreeCopier(t *testing.T, context spec.G, it spec.S) {
+	var (
+		Expect = NewWithT(t).Expect
+
+		source      string
+		destination string
+	)
+
+	it.Before(func() {
+		source = filepath.Join(t.TempDir(), "node_modules")
+		destination = filepath.Join(t.TempDir(), "node_modules")
+
+		Expect(os.MkdirAll(filepath.Join(source, "some-package", "lib"), os.ModePerm)).To(Succeed())
+		Expect(os.MkdirAll(filepath.Join(source, ".bin"), os.ModePerm)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(source, "some-package", "package.json"), []byte(`{"name": "some-package"}`), 0644)).To(Succeed())
+		Expect(os.WriteFile(filepath.Join(source, "some-package", "lib", "cli.js"), []byte("#!/usr/bin/env node"), 0755)).To(Succeed())
+		Expect(os.Symlink(filepath.Join("..", "some-package", "lib", "cli.js"), filepath.Join(source, ".bin", "some-cli"))).To(Succeed())
+	})
+
+	expectCopied := func() {
+		content, err := os.ReadFile(filepath.Join(destination, "some-package", "package.json"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(string(content)).To(Equal(`{"name": "some-package"}`))
+
+		info, err := os.Stat(filepath.Join(destination, "some-package", "lib", "cli.js"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(info.Mode().Perm()).To(Equal(os.FileMode(0755)))
+
+		link, err := os.Readlink(filepath.Join(destination, ".bin", "some-cli"))
+		Expect(err).NotTo(HaveOccurred())
+		Expect(link).To(Equal(filepath.Join("..", "some-package", "lib", "cli.js")))
+	}
+
+	sameFile := func() bool {
+		sourceInfo, err := os.Stat(filepath.Join(source, "some-package", "package.json"))
+		Expect(err).NotTo(HaveOccurred())
+
+		destinationInfo, err := os.Stat(filepath.Join(destination, "some-package", "package.json"))
+		Expect(err).NotTo(HaveOccurred())
+
+		return os.SameFile(sourceInfo, destinationInfo)
+	}
+
+	context("Copy", func() {
+		it("copies the tree", func() {
+			Expect(npminstall.NewTreeCopier().Copy(source, destination)).To(Succeed())
+			expectCopied()
+		})
+
+		it("does not share the files with the source by default", func() {
+			Expect(npminstall.NewTreeCopier().Copy(source, destination)).To(Succeed())
+			Expect(sameFile()).To(BeFalse())
+		})
+
+		context("when the destination exists", func() {
+			it.Before(func() {
+				Expect(os.MkdirAll(filepath.Join(destination, "some-stale-package"), os.ModePerm)).To(Succeed())
+			})
+
+			it("replaces it", func() {
+				Expect(npminstall.NewTreeCopier().Copy(source, destination)).To(Succeed())
+				expectCopied()
+				Expect(filepath.Join(destination, "some-stale-package")).NotTo(BeADirectory())
+			})
+		})
+
+		context("when hardlinking", func() {
+			it("shares the files with the source", func() {
+				Expect(npminstall.NewTreeCopier(npminstall.CopyMethodHardlink).Copy(source, destination)).To(Succeed())
+				expectCopied()
+				Expect(sameFile()).To(BeTrue())
+			})
+		})
+
+		context("when copying", func() {
+			it("does not share the files with the source", func() {
+				Expect(npminstall.NewTreeCopier(npminstall.CopyMethodCopy).Copy(source, destination)).To(Succeed())
+				expectCopied()
+				Expect(sameFile()).To(BeFalse())
+			})
+		})
+
+		context("when cloning is not supported by the filesystem", func() {
+			it("falls back to the next method", func() {
+				Expect(npminstall.NewTreeCopier(npminstall.CopyMethodReflink, npminstall.CopyMethodCopy).Copy(source, destination)).To(Succeed())
+				expectCopied()
+				Expect(sameFile()).To(BeFalse())
+			})
+		})
+
+		context("failure cases", func() {
+			context("when the source does not exist", func() {
+				it("returns an error", func() {
+					err := npminstall.NewTreeCopier().Copy(filepath.Join(source, "missing"), destination)
+					Expect(err).To(MatchError(ContainSubstring("no such file or directory")))
+				})
+			})
+
+			context("when the copy method is unknown", func() {
+				it("returns an error", func() {
+					err := npminstall.NewTreeCopier("some-method").Copy(source, destination)
+					Expect(err).To(MatchError(`unknown copy method "some-method"`))
+				})
+			})
+		})
+	})
+}
+
+func BenchmarkTreeCopier(b *testing.B) {
+	source := filepath.Join(b.TempDir(), "node_modules")
+	content := bytes.Repeat([]byte("x"), 4096)
+	for i := 0; i < 200; i++ {
+		dir := filepath.Join(source, fmt.Sprintf("package-%d", i), "lib")
+		if err := os.MkdirAll(dir, os.ModePerm); err != nil {
+			b.Fatal(err)
+		}
+
+		for j := 0; j < 20; j++ {
+			if err := os.WriteFile(filepath.Join(dir, fmt.Sprintf("file-%d.js", j)), content, 0644); err != nil {
+				b.Fatal(err)
+			}
+		}
+	}
+
+	copiers := map[string]npminstall.TreeCopier{
+		"default":      npminstall.NewTreeCopier(),
+		"before-prune": npminstall.NewTreeCopier(npminstall.CopyMethodReflink, npminstall.CopyMethodHardlink, npminstall.CopyMethodCopy),
+		"reflink":      npminstall.NewTreeCopier(npminstall.CopyMethodReflink),
+		"hardlink":     npminstall.NewTreeCopier(npminstall.CopyMethodHardlink),
+		"copy":         npminstall.NewTreeCopier(npminstall.CopyMethodCopy),
+	}
+
+	for _, name := range []string{"default", "before-prune", "reflink", "hardlink", "copy"} {
+		b.Run(name, func(b *testing.B) {
+			copier := copiers[name]
+			destination := filepath.Join(b.TempDir(), "node_modules")
+
+			for i := 0; i < b.N; i++ {
+				err := copier.Copy(source, destination)
+				if err != nil {
+					b.Skipf("%s is not supported here: %s", name, err)
+				}
+			}
+		})
+	}
+}
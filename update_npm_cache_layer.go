@@ -1,42 +1,187 @@
 package npminstall
 
 import (
+	"errors"
 	"os"
 	"path/filepath"
+	"strings"
 
 	"github.com/paketo-buildpacks/packit/v2"
 	"github.com/paketo-buildpacks/packit/v2/fs"
 	"github.com/paketo-buildpacks/packit/v2/scribe"
 )
 
-func UpdateNpmCacheLayer(logger scribe.Emitter, workingDir string, cacheLayer packit.Layer) (packit.Layer, error) {
+// UpdateNpmCacheLayer merges the npm-cache directory vendored with the app
+// into the cache layer, unless the layer already holds a vendored cache with
+// the same fingerprint and none of its content has been removed from the layer
+// since. In both cases the npm-cache directory is removed from the app so that
+// it does not ship in the app image.
+func UpdateNpmCacheLayer(logger scribe.Emitter, summer Summer, workingDir string, cacheLayer packit.Layer) (packit.Layer, error) {
 	npmCachePath := filepath.Join(workingDir, "npm-cache")
-	sum, err := fs.NewChecksumCalculator().Sum(npmCachePath)
+	sum, err := summer.Sum(npmCachePath)
 	if err != nil {
 		return packit.Layer{}, err
 	}
 
-	cacheSha, ok := cacheLayer.Metadata["cache_sha"].(string)
-	if !ok || sum != cacheSha {
+	merge := true
+	if cacheSha, ok := cacheLayer.Metadata["cache_sha"].(string); ok && sum == cacheSha {
+		merge, err = npmCacheContentMissing(npmCachePath, cacheLayer.Path)
 		if err != nil {
 			return packit.Layer{}, err
 		}
+	}
+
+	if merge {
+		logger.Process("Merging vendored npm-cache into layer %s", cacheLayer.Path)
 
-		err = fs.Move(npmCachePath, cacheLayer.Path)
+		err = mergeNpmCache(npmCachePath, cacheLayer.Path)
 		if err != nil {
 			return packit.Layer{}, err
 		}
 
-		cacheLayer.Metadata = map[string]interface{}{
-			"cache_sha": sum,
+		if cacheLayer.Metadata == nil {
+			cacheLayer.Metadata = map[string]interface{}{}
 		}
+		cacheLayer.Metadata["cache_sha"] = sum
 	} else {
 		logger.Process("Reusing cached layer %s", cacheLayer.Path)
-		err = os.RemoveAll(npmCachePath)
+	}
+
+	err = os.RemoveAll(npmCachePath)
+	if err != nil {
+		return packit.Layer{}, err
+	}
+
+	return cacheLayer, nil
+}
+
+// npmCacheContentMissing reports whether any content file of the source npm
+// cache is missing from the destination.
+func npmCacheContentMissing(source, destination string) (bool, error) {
+	contentDir := filepath.Join("_cacache", "content-v2")
+
+	var missing bool
+	err := filepath.Walk(filepath.Join(source, contentDir), func(path string, info os.FileInfo, err error) error {
 		if err != nil {
-			return packit.Layer{}, err
+			if errors.Is(err, os.ErrNotExist) {
+				return nil
+			}
+			return err
+		}
+
+		if info.IsDir() {
+			return nil
+		}
+
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+
+		exists, err := fs.Exists(filepath.Join(destination, rel))
+		if err != nil {
+			return err
+		}
+
+		if !exists {
+			missing = true
+			return filepath.SkipAll
 		}
+
+		return nil
+	})
+	if err != nil {
+		return false, err
 	}
 
-	return cacheLayer, nil
+	return missing, nil
+}
+
+// mergeNpmCache moves the files of the source npm cache into the destination.
+// Content files are addressed by their integrity, so those that already exist
+// in the destination are identical and are left in place. Index buckets that
+// exist in both caches are merged line by line. Any other file replaces the
+// one in the destination.
+func mergeNpmCache(source, destination string) error {
+	contentDir := filepath.Join("_cacache", "content-v2") + string(filepath.Separator)
+	indexDir := filepath.Join("_cacache", "index-v5") + string(filepath.Separator)
+
+	return filepath.Walk(source, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(source, path)
+		if err != nil {
+			return err
+		}
+		target := filepath.Join(destination, rel)
+
+		if info.IsDir() {
+			return os.MkdirAll(target, os.ModePerm)
+		}
+
+		exists, err := fs.Exists(target)
+		if err != nil {
+			return err
+		}
+
+		switch {
+		case exists && strings.HasPrefix(rel, contentDir):
+			return nil
+
+		case exists && strings.HasPrefix(rel, indexDir):
+			return mergeNpmCacheIndexBucket(path, target)
+
+		case exists:
+			err = os.RemoveAll(target)
+			if err != nil {
+				return err
+			}
+		}
+
+		return fs.Move(path, target)
+	})
+}
+
+// mergeNpmCacheIndexBucket appends the entries of the source bucket that the
+// destination bucket does not hold yet.
+func mergeNpmCacheIndexBucket(source, destination string) error {
+	sourceContent, err := os.ReadFile(source)
+	if err != nil {
+		return err
+	}
+
+	destinationContent, err := os.ReadFile(destination)
+	if err != nil {
+		return err
+	}
+
+	existing := map[string]bool{}
+	for _, line := range strings.Split(string(destinationContent), "\n") {
+		existing[line] = true
+	}
+
+	var missing strings.Builder
+	for _, line := range strings.Split(string(sourceContent), "\n") {
+		if line == "" || existing[line] {
+			continue
+		}
+
+		existing[line] = true
+		missing.WriteString("\n")
+		missing.WriteString(line)
+	}
+
+	if missing.Len() == 0 {
+		return nil
+	}
+
+	file, err := os.OpenFile(destination, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = file.WriteString(missing.String())
+	return errors.Join(err, file.Close())
 }
@@ -8,7 +8,6 @@ import (
 
 	npminstall "github.com/paketo-buildpacks/npm-install"
 	"github.com/paketo-buildpacks/packit/v2"
-	"github.com/paketo-buildpacks/packit/v2/fs"
 	"github.com/paketo-buildpacks/packit/v2/scribe"
 	"github.com/sclevine/spec"
 
@@ -21,6 +20,7 @@ func testUpdateNpmCache(t *testing.T, context spec.G, it spec.S) {
 		buf    *bytes.Buffer
 		logger scribe.Emitter
 
+		summer        npminstall.Fingerprinter
 		layersDir     string
 		workingDir    string
 		workingDirSum string
@@ -41,7 +41,8 @@ func testUpdateNpmCache(t *testing.T, context spec.G, it spec.S) {
 		Expect(os.MkdirAll(filepath.Join(workingDir, "npm-cache"), os.ModePerm)).To(Succeed())
 		Expect(os.WriteFile(filepath.Join(workingDir, "npm-cache", "some-file"), []byte("some-content"), os.ModePerm)).To(Succeed())
 
-		workingDirSum, err = fs.NewChecksumCalculator().Sum(filepath.Join(workingDir, "npm-cache"))
+		summer = npminstall.NewFingerprinter(npminstall.FingerprintModeManifest)
+		workingDirSum, err = summer.Sum(filepath.Join(workingDir, "npm-cache"))
 		Expect(err).NotTo(HaveOccurred())
 
 		layers := packit.Layers{Path: layersDir}
@@ -57,12 +58,61 @@ func testUpdateNpmCache(t *testing.T, context spec.G, it spec.S) {
 	context("UpdateNpmCacheLayer", func() {
 		context("when cache layer is stale", func() {
 			it("updates cache layer", func() {
-				layer, err := npminstall.UpdateNpmCacheLayer(logger, workingDir, cacheLayer)
+				layer, err := npminstall.UpdateNpmCacheLayer(logger, summer, workingDir, cacheLayer)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(layer.Metadata).To(HaveKeyWithValue("cache_sha", workingDirSum))
 				Expect(buf.String()).NotTo(ContainSubstring("Reusing cached layer"))
 
 				Expect(filepath.Join(layer.Path, "some-file")).To(BeARegularFile())
+				Expect(filepath.Join(workingDir, "npm-cache")).NotTo(BeAnExistingFile())
+			})
+
+			context("when the layer already holds cached entries", func() {
+				var contentPath, bucketPath string
+
+				it.Before(func() {
+					contentPath = filepath.Join("_cacache", "content-v2", "sha512", "ab", "cd", "ef")
+					bucketPath = filepath.Join("_cacache", "index-v5", "12", "34", "56")
+
+					for _, dir := range []string{cacheLayer.Path, filepath.Join(workingDir, "npm-cache")} {
+						Expect(os.MkdirAll(filepath.Join(dir, filepath.Dir(contentPath)), os.ModePerm)).To(Succeed())
+						Expect(os.MkdirAll(filepath.Join(dir, filepath.Dir(bucketPath)), os.ModePerm)).To(Succeed())
+					}
+
+					Expect(os.WriteFile(filepath.Join(cacheLayer.Path, "previous-file"), []byte("previous-content"), os.ModePerm)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(cacheLayer.Path, contentPath), []byte("some-tarball"), os.ModePerm)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(cacheLayer.Path, bucketPath), []byte("\nhash-1\t{\"key\":\"some-key\"}\nhash-2\t{\"key\":\"other-key\"}"), os.ModePerm)).To(Succeed())
+
+					Expect(os.WriteFile(filepath.Join(workingDir, "npm-cache", contentPath), []byte("some-tarball"), os.ModePerm)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(workingDir, "npm-cache", bucketPath), []byte("\nhash-2\t{\"key\":\"other-key\"}\nhash-3\t{\"key\":\"new-key\"}"), os.ModePerm)).To(Succeed())
+
+					cacheLayer.Metadata = map[string]interface{}{
+						"cache_sha":  "some-other-sha",
+						"cache_size": int64(12),
+					}
+				})
+
+				it("merges the vendored cache into the layer", func() {
+					layer, err := npminstall.UpdateNpmCacheLayer(logger, summer, workingDir, cacheLayer)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(layer.Metadata).To(HaveKey("cache_sha"))
+					Expect(layer.Metadata["cache_sha"]).NotTo(Equal("some-other-sha"))
+					Expect(layer.Metadata).To(HaveKeyWithValue("cache_size", int64(12)))
+					Expect(buf.String()).To(ContainSubstring("Merging vendored npm-cache into layer"))
+
+					Expect(filepath.Join(layer.Path, "previous-file")).To(BeARegularFile())
+					Expect(filepath.Join(layer.Path, "some-file")).To(BeARegularFile())
+
+					content, err := os.ReadFile(filepath.Join(layer.Path, contentPath))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(content)).To(Equal("some-tarball"))
+
+					content, err = os.ReadFile(filepath.Join(layer.Path, bucketPath))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(content)).To(Equal("\nhash-1\t{\"key\":\"some-key\"}\nhash-2\t{\"key\":\"other-key\"}\nhash-3\t{\"key\":\"new-key\"}"))
+
+					Expect(filepath.Join(workingDir, "npm-cache")).NotTo(BeAnExistingFile())
+				})
 			})
 		})
 
@@ -75,10 +125,50 @@ func testUpdateNpmCache(t *testing.T, context spec.G, it spec.S) {
 				}
 			})
 			it("reuses the layer", func() {
-				layer, err := npminstall.UpdateNpmCacheLayer(logger, workingDir, cacheLayer)
+				layer, err := npminstall.UpdateNpmCacheLayer(logger, summer, workingDir, cacheLayer)
 				Expect(err).NotTo(HaveOccurred())
 				Expect(layer.Metadata).To(HaveKeyWithValue("cache_sha", workingDirSum))
 				Expect(buf.String()).To(ContainSubstring("Reusing cached layer"))
+				Expect(filepath.Join(workingDir, "npm-cache")).NotTo(BeAnExistingFile())
+			})
+
+			context("when content of the vendored cache has been removed from the layer", func() {
+				var contentPath string
+
+				it.Before(func() {
+					contentPath = filepath.Join("_cacache", "content-v2", "sha512", "ab", "cd", "ef")
+					Expect(os.MkdirAll(filepath.Join(workingDir, "npm-cache", filepath.Dir(contentPath)), os.ModePerm)).To(Succeed())
+					Expect(os.WriteFile(filepath.Join(workingDir, "npm-cache", contentPath), []byte("some-tarball"), os.ModePerm)).To(Succeed())
+
+					workingDirSum, err = summer.Sum(filepath.Join(workingDir, "npm-cache"))
+					Expect(err).NotTo(HaveOccurred())
+					cacheLayer.Metadata["cache_sha"] = workingDirSum
+				})
+
+				it("merges the vendored cache into the layer again", func() {
+					layer, err := npminstall.UpdateNpmCacheLayer(logger, summer, workingDir, cacheLayer)
+					Expect(err).NotTo(HaveOccurred())
+					Expect(layer.Metadata).To(HaveKeyWithValue("cache_sha", workingDirSum))
+					Expect(buf.String()).To(ContainSubstring("Merging vendored npm-cache into layer"))
+					Expect(buf.String()).NotTo(ContainSubstring("Reusing cached layer"))
+
+					content, err := os.ReadFile(filepath.Join(layer.Path, contentPath))
+					Expect(err).NotTo(HaveOccurred())
+					Expect(string(content)).To(Equal("some-tarball"))
+				})
+
+				context("when the layer still holds it", func() {
+					it.Before(func() {
+						Expect(os.MkdirAll(filepath.Join(cacheLayer.Path, filepath.Dir(contentPath)), os.ModePerm)).To(Succeed())
+						Expect(os.WriteFile(filepath.Join(cacheLayer.Path, contentPath), []byte("some-tarball"), os.ModePerm)).To(Succeed())
+					})
+
+					it("reuses the layer", func() {
+						_, err := npminstall.UpdateNpmCacheLayer(logger, summer, workingDir, cacheLayer)
+						Expect(err).NotTo(HaveOccurred())
+						Expect(buf.String()).To(ContainSubstring("Reusing cached layer"))
+					})
+				})
 			})
 		})
 
@@ -93,7 +183,7 @@ func testUpdateNpmCache(t *testing.T, context spec.G, it spec.S) {
 				})
 
 				it("returns an error", func() {
-					_, err = npminstall.UpdateNpmCacheLayer(logger, workingDir, cacheLayer)
+					_, err = npminstall.UpdateNpmCacheLayer(logger, summer, workingDir, cacheLayer)
 					Expect(err).To(MatchError(ContainSubstring("permission denied")))
 				})
 			})
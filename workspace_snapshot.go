@@ -0,0 +1,86 @@
+package npminstall
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/paketo-buildpacks/packit/v2/fs"
+)
+
+// workspaceSnapshot records the state of node_modules in the working
+// directory before a layer is installed, so that a failed install can be
+// rolled back.
+type workspaceSnapshot struct {
+	path   string
+	exists bool
+	link   string
+}
+
+func snapshotWorkspace(projectPath string) (workspaceSnapshot, error) {
+	snapshot := workspaceSnapshot{path: filepath.Join(projectPath, "node_modules")}
+
+	info, err := os.Lstat(snapshot.path)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return snapshot, nil
+		}
+		return workspaceSnapshot{}, err
+	}
+
+	snapshot.exists = true
+	if info.Mode()&os.ModeSymlink != 0 {
+		snapshot.link, err = os.Readlink(snapshot.path)
+		if err != nil {
+			return workspaceSnapshot{}, err
+		}
+	}
+
+	return snapshot, nil
+}
+
+// restore puts node_modules in the working directory back into its recorded
+// state. A vendored node_modules directory that was already moved into the
+// given layer directory is moved back.
+func (s workspaceSnapshot) restore(layerPath string) error {
+	info, err := os.Lstat(s.path)
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	current := err == nil
+
+	switch {
+	case !s.exists:
+		return os.RemoveAll(s.path)
+
+	case s.link != "":
+		err = os.RemoveAll(s.path)
+		if err != nil {
+			return err
+		}
+
+		return os.Symlink(s.link, s.path)
+
+	case !current || info.Mode()&os.ModeSymlink != 0:
+		err = os.RemoveAll(s.path)
+		if err != nil {
+			return err
+		}
+
+		moved := filepath.Join(layerPath, "node_modules")
+		exists, err := fs.Exists(moved)
+		if err != nil {
+			return err
+		}
+
+		if exists {
+			err = fs.Move(moved, s.path)
+			if err != nil {
+				return fmt.Errorf("failed to restore vendored node_modules: %w", err)
+			}
+		}
+	}
+
+	return nil
+}